@@ -1,1112 +1,46 @@
+// Command cache-warmer keeps a site's cache warm by fetching the URLs listed
+// in its sitemaps. It is a CLI over the warmer package.
 package main
 
 import (
-	"compress/gzip"
+	"bytes"
 	"context"
-	"database/sql"
-	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/fatih/color"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/hpowernl/cache-warmer/warmer"
 )
 
-// ============================
-// Configuration
-// ============================
-
-// HTTP status code constants
-const (
-	httpStatusOK         = 200
-	httpStatusClientErr  = 400
-	httpStatusSuccessMax = 399
-	httpStatusTooMany    = 429
-)
-
-// Display truncation limits for status output
-const (
-	truncateURLLong      = 50
-	truncateURLShort     = 45
-	truncateURLSitemap   = 55
-	truncateErrorMsg     = 30
-	maxTimestampDisplay  = 19
-)
-
-const defaultConfigTOML = `[app]
-# Paths are resolved relative to this config file location.
-db_path = "warmer.db"
-log_file = "logs/cache_warmer.log"
-log_level = "INFO"
-
-# Rewarm URLs if last warm is older than this many hours (unless a flush happened after that warm).
-rewarm_after_hours = 24
-
-# If loop=true, keeps running and re-processes sitemaps every loop_interval_seconds
-loop = true
-loop_interval_seconds = 900
-
-[http]
-user_agent = "CacheWarmer/1.0 (+cachewarmer)"
-timeout_seconds = 20
-connect_timeout_seconds = 10
-max_redirects = 5
-
-# Concurrency / pacing
-concurrency = 8
-min_delay_ms = 50
-
-# Retries
-retries = 2
-retry_backoff_seconds = 1.0
-
-# 429 rate limit handling
-rate_limit_cooldown_seconds = 120
-rate_limit_recover_after = 50
-rate_limit_max_429_retries = 10
-
-[load]
-# 1-minute load average limit. For 4 CPUs and "must not exceed 3", use 2.0.
-max_load = 2.0
-check_interval_seconds = 2
-
-[sitemaps]
-urls = [
-  "https://www.demoshop.nl/sitemap.xml"
-]
-`
-
-type Config struct {
-	App      AppConfig      `toml:"app"`
-	HTTP     HTTPConfig     `toml:"http"`
-	Load     LoadConfig     `toml:"load"`
-	Sitemaps SitemapsConfig `toml:"sitemaps"`
-}
-
-type AppConfig struct {
-	DBPath              string `toml:"db_path"`
-	LogFile             string `toml:"log_file"`
-	LogLevel            string `toml:"log_level"`
-	RewarmAfterHours    int    `toml:"rewarm_after_hours"`
-	Loop                bool   `toml:"loop"`
-	LoopIntervalSeconds int    `toml:"loop_interval_seconds"`
-}
-
-type HTTPConfig struct {
-	UserAgent                  string  `toml:"user_agent"`
-	TimeoutSeconds             int     `toml:"timeout_seconds"`
-	ConnectTimeoutSeconds      int     `toml:"connect_timeout_seconds"`
-	MaxRedirects               int     `toml:"max_redirects"`
-	Concurrency               int     `toml:"concurrency"`
-	MinDelayMS                int     `toml:"min_delay_ms"`
-	Retries                   int     `toml:"retries"`
-	RetryBackoffSeconds       float64 `toml:"retry_backoff_seconds"`
-	RateLimitCooldownSeconds  int     `toml:"rate_limit_cooldown_seconds"`
-	RateLimitRecoverAfter     int     `toml:"rate_limit_recover_after"`
-	RateLimitMax429Retries    int     `toml:"rate_limit_max_429_retries"`
-}
-
-type LoadConfig struct {
-	MaxLoad              float64 `toml:"max_load"`
-	CheckIntervalSeconds int     `toml:"check_interval_seconds"`
-}
-
-type SitemapsConfig struct {
-	URLs []string `toml:"urls"`
-}
-
-// ============================
-// Database
-// ============================
-
-const schema = `
-CREATE TABLE IF NOT EXISTS warmed_url (
-  url TEXT PRIMARY KEY,
-  last_warmed_utc TEXT,
-  last_status INTEGER,
-  last_error TEXT,
-  warmed_count INTEGER DEFAULT 0
-);
-
-CREATE TABLE IF NOT EXISTS sitemap_seen (
-  sitemap_url TEXT PRIMARY KEY,
-  last_fetched_utc TEXT,
-  last_error TEXT
-);
-
-CREATE TABLE IF NOT EXISTS meta (
-  k TEXT PRIMARY KEY,
-  v TEXT
-);
-`
-
-type WarmDB struct {
-	db *sql.DB
-}
-
-func NewWarmDB(path string) (*WarmDB, error) {
-	// Ensure directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
-	}
-
-	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=NORMAL")
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := db.Exec(schema); err != nil {
-		db.Close()
-		return nil, err
-	}
-
-	return &WarmDB{db: db}, nil
-}
-
-func (w *WarmDB) Close() error {
-	return w.db.Close()
-}
-
-func (w *WarmDB) GetLastFlush() (*time.Time, error) {
-	var v string
-	err := w.db.QueryRow("SELECT v FROM meta WHERE k='last_flush_utc'").Scan(&v)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	t, err := time.Parse(time.RFC3339, v)
-	if err != nil {
-		return nil, nil
-	}
-	return &t, nil
-}
-
-func (w *WarmDB) MarkFlush(reason string) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := w.db.Exec(`INSERT INTO meta(k, v) VALUES('last_flush_utc', ?) 
-		ON CONFLICT(k) DO UPDATE SET v=excluded.v`, now)
-	if err != nil {
-		return err
-	}
-
-	if reason != "" {
-		_, err = w.db.Exec(`INSERT INTO meta(k, v) VALUES('last_flush_reason', ?) 
-			ON CONFLICT(k) DO UPDATE SET v=excluded.v`, reason)
-	}
-	return err
-}
-
-func (w *WarmDB) ShouldWarm(url string, rewarmAfter time.Duration) (bool, error) {
-	lastFlush, err := w.GetLastFlush()
-	if err != nil {
-		return false, err
-	}
-
-	var lastWarmedStr string
-	err = w.db.QueryRow("SELECT last_warmed_utc FROM warmed_url WHERE url = ?", url).Scan(&lastWarmedStr)
-	if err == sql.ErrNoRows {
-		return true, nil
-	}
-	if err != nil {
-		return false, err
-	}
-
-	lastWarmed, err := time.Parse(time.RFC3339, lastWarmedStr)
-	if err != nil {
-		return true, nil
-	}
-
-	// If cache flush happened after last warm, rewarm
-	if lastFlush != nil && lastWarmed.Before(*lastFlush) {
-		return true, nil
-	}
-
-	// Otherwise apply normal rewarm policy
-	return time.Since(lastWarmed) >= rewarmAfter, nil
-}
-
-func (w *WarmDB) MarkWarmed(url string, status int, errorMsg string) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	var errVal interface{}
-	if errorMsg != "" {
-		errVal = errorMsg
-	}
-
-	var count int
-	err := w.db.QueryRow("SELECT warmed_count FROM warmed_url WHERE url = ?", url).Scan(&count)
-
-	if err == sql.ErrNoRows {
-		_, err = w.db.Exec(`INSERT INTO warmed_url(url, last_warmed_utc, last_status, last_error, warmed_count) 
-			VALUES(?,?,?,?,1)`, url, now, status, errVal)
-		return err
-	}
-
-	if err != nil {
-		return err
-	}
-
-	_, err = w.db.Exec(`UPDATE warmed_url SET last_warmed_utc=?, last_status=?, last_error=?, warmed_count=warmed_count+1 
-		WHERE url=?`, now, status, errVal, url)
-	return err
-}
-
-func (w *WarmDB) MarkSitemap(sitemapURL string, errorMsg string) error {
-	now := time.Now().UTC().Format(time.RFC3339)
-	var errVal interface{}
-	if errorMsg != "" {
-		errVal = errorMsg
-	}
-
-	var exists bool
-	err := w.db.QueryRow("SELECT 1 FROM sitemap_seen WHERE sitemap_url = ?", sitemapURL).Scan(&exists)
-
-	if err == sql.ErrNoRows {
-		_, err = w.db.Exec(`INSERT INTO sitemap_seen(sitemap_url, last_fetched_utc, last_error) 
-			VALUES(?,?,?)`, sitemapURL, now, errVal)
-		return err
-	}
-
-	if err != nil {
-		return err
-	}
-
-	_, err = w.db.Exec(`UPDATE sitemap_seen SET last_fetched_utc=?, last_error=? 
-		WHERE sitemap_url=?`, now, errVal, sitemapURL)
-	return err
-}
-
-type Stats struct {
-	WarmedTotal  int
-	OKTotal      int
-	ErrTotal     int
-	LastFlushUTC string
-}
-
-func (w *WarmDB) Stats() (*Stats, error) {
-	var s Stats
-
-	err := w.db.QueryRow("SELECT COUNT(*) FROM warmed_url").Scan(&s.WarmedTotal)
-	if err != nil {
-		return nil, err
-	}
-
-	err = w.db.QueryRow(`SELECT COUNT(*) FROM warmed_url 
-		WHERE last_error IS NULL AND last_status BETWEEN ? AND ?`, httpStatusOK, httpStatusSuccessMax).Scan(&s.OKTotal)
-	if err != nil {
-		return nil, err
-	}
-
-	err = w.db.QueryRow(`SELECT COUNT(*) FROM warmed_url 
-		WHERE last_error IS NOT NULL OR last_status >= ? OR last_status = 0`, httpStatusClientErr).Scan(&s.ErrTotal)
-	if err != nil {
-		return nil, err
-	}
-
-	lastFlush, err := w.GetLastFlush()
-	if err != nil {
-		return nil, fmt.Errorf("getting last flush: %w", err)
-	}
-	if lastFlush != nil {
-		s.LastFlushUTC = lastFlush.Format(time.RFC3339)
-	}
-
-	return &s, nil
-}
-
-type RecentURL struct {
-	URL       string
-	Timestamp string
-	Status    int
-	Error     sql.NullString
-}
-
-func (w *WarmDB) GetRecentWarmed(limit int) ([]RecentURL, error) {
-	rows, err := w.db.Query(`SELECT url, last_warmed_utc, last_status, last_error 
-		FROM warmed_url ORDER BY last_warmed_utc DESC LIMIT ?`, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []RecentURL
-	for rows.Next() {
-		var r RecentURL
-		if err := rows.Scan(&r.URL, &r.Timestamp, &r.Status, &r.Error); err != nil {
-			return nil, err
-		}
-		results = append(results, r)
-	}
-	return results, rows.Err()
-}
-
-func (w *WarmDB) GetFailedURLs(limit int) ([]RecentURL, error) {
-	rows, err := w.db.Query(`SELECT url, last_warmed_utc, last_status, last_error 
-		FROM warmed_url 
-		WHERE last_error IS NOT NULL OR last_status >= ? OR last_status = 0 
-		ORDER BY last_warmed_utc DESC LIMIT ?`, httpStatusClientErr, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []RecentURL
-	for rows.Next() {
-		var r RecentURL
-		if err := rows.Scan(&r.URL, &r.Timestamp, &r.Status, &r.Error); err != nil {
-			return nil, err
-		}
-		results = append(results, r)
-	}
-	return results, rows.Err()
-}
-
-type SitemapStatus struct {
-	URL       string
-	Timestamp string
-	Error     sql.NullString
-}
-
-func (w *WarmDB) GetSitemapStatus() ([]SitemapStatus, error) {
-	rows, err := w.db.Query(`SELECT sitemap_url, last_fetched_utc, last_error 
-		FROM sitemap_seen ORDER BY last_fetched_utc DESC`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []SitemapStatus
-	for rows.Next() {
-		var s SitemapStatus
-		if err := rows.Scan(&s.URL, &s.Timestamp, &s.Error); err != nil {
-			return nil, err
-		}
-		results = append(results, s)
-	}
-	return results, rows.Err()
-}
-
-// ============================
-// Sitemap Parsing
-// ============================
-
-type Sitemap struct {
-	XMLName xml.Name       `xml:"urlset"`
-	URLs    []SitemapURL   `xml:"url"`
-	Sitemap []SitemapIndex `xml:"sitemap"`
-}
-
-type SitemapURL struct {
-	Loc string `xml:"loc"`
-}
-
-type SitemapIndex struct {
-	Loc string `xml:"loc"`
-}
-
-type SitemapIndexRoot struct {
-	XMLName  xml.Name       `xml:"sitemapindex"`
-	Sitemaps []SitemapIndex `xml:"sitemap"`
-}
-
-func parseSitemapXML(data []byte) ([]string, []string, error) {
-	var childSitemaps []string
-	var urls []string
-
-	// Try parsing as sitemapindex
-	var idx SitemapIndexRoot
-	if err := xml.Unmarshal(data, &idx); err == nil && len(idx.Sitemaps) > 0 {
-		for _, s := range idx.Sitemaps {
-			if s.Loc != "" {
-				childSitemaps = append(childSitemaps, strings.TrimSpace(s.Loc))
-			}
-		}
-	}
-
-	// Try parsing as urlset
-	var urlset Sitemap
-	if err := xml.Unmarshal(data, &urlset); err == nil {
-		for _, u := range urlset.URLs {
-			if u.Loc != "" {
-				urls = append(urls, strings.TrimSpace(u.Loc))
-			}
-		}
-		for _, s := range urlset.Sitemap {
-			if s.Loc != "" {
-				childSitemaps = append(childSitemaps, strings.TrimSpace(s.Loc))
-			}
-		}
-	}
-
-	return childSitemaps, urls, nil
-}
-
-// ============================
-// Load Monitoring
-// ============================
-
-func getLoad1m() (float64, error) {
-	// Try to read /proc/loadavg on Linux
-	data, err := os.ReadFile("/proc/loadavg")
-	if err == nil {
-		var load1, load5, load15 float64
-		_, err := fmt.Sscanf(string(data), "%f %f %f", &load1, &load5, &load15)
-		if err == nil {
-			return load1, nil
-		}
-	}
-
-	// Fallback: load monitoring not available on this platform
-	return 0, fmt.Errorf("load monitoring not available on this platform")
-}
-
-func waitForLoad(ctx context.Context, cfg LoadConfig) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		load, err := getLoad1m()
-		if err != nil {
-			// Cannot measure load, don't block
-			return nil
-		}
-
-		if load <= cfg.MaxLoad {
-			return nil
-		}
-
-		log.Printf("Load too high (1m=%.2f > max=%.2f). Sleeping %ds...",
-			load, cfg.MaxLoad, cfg.CheckIntervalSeconds)
-
-		select {
-		case <-time.After(time.Duration(cfg.CheckIntervalSeconds) * time.Second):
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
-}
-
-// ============================
-// Rate Limiter (429 adaptive)
-// ============================
-
-type rateLimiter struct {
-	mu                sync.Mutex
-	cond              *sync.Cond
-	currentConcurrency int
-	minConcurrency     int
-	maxConcurrency     int
-	activeWorkers     int
-	cooldownUntil     time.Time
-	consecutiveOK     int
-	recoverAfter      int
-	cooldownSeconds   int
-}
-
-func newRateLimiter(concurrency, cooldownSeconds, recoverAfter int) *rateLimiter {
-	rl := &rateLimiter{
-		currentConcurrency: concurrency,
-		minConcurrency:     1,
-		maxConcurrency:     concurrency,
-		activeWorkers:      0,
-		cooldownUntil:      time.Time{},
-		consecutiveOK:      0,
-		recoverAfter:       recoverAfter,
-		cooldownSeconds:    cooldownSeconds,
-	}
-	rl.cond = sync.NewCond(&rl.mu)
-	return rl
-}
-
-func (rl *rateLimiter) acquire(ctx context.Context) error {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	done := make(chan struct{})
-	defer close(done)
-	go func() {
-		select {
-		case <-ctx.Done():
-			rl.mu.Lock()
-			rl.cond.Broadcast()
-			rl.mu.Unlock()
-		case <-done:
-		}
-	}()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-		now := time.Now()
-		if now.Before(rl.cooldownUntil) {
-			d := time.Until(rl.cooldownUntil)
-			rl.mu.Unlock()
-			select {
-			case <-ctx.Done():
-				rl.mu.Lock()
-				return ctx.Err()
-			case <-time.After(d):
-			}
-			rl.mu.Lock()
-			continue
-		}
-		if rl.activeWorkers < rl.currentConcurrency {
-			rl.activeWorkers++
-			return nil
-		}
-		rl.cond.Wait()
-	}
-}
-
-func (rl *rateLimiter) release() {
-	rl.mu.Lock()
-	rl.activeWorkers--
-	rl.cond.Broadcast()
-	rl.mu.Unlock()
-}
-
-func (rl *rateLimiter) on429(retryAfter time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-	now := time.Now()
-	// Debounce: only reduce concurrency once per cooldown period to avoid
-	// aggressive drops when many workers get 429 simultaneously.
-	if now.Before(rl.cooldownUntil) {
-		// Already in cooldown; optionally extend if Retry-After is longer
-		cooldown := retryAfter
-		if cooldown < time.Duration(rl.cooldownSeconds)*time.Second {
-			cooldown = time.Duration(rl.cooldownSeconds) * time.Second
-		}
-		newUntil := now.Add(cooldown)
-		if newUntil.After(rl.cooldownUntil) {
-			rl.cooldownUntil = newUntil
-		}
-		rl.cond.Broadcast()
-		return
-	}
-	newConcurrency := rl.currentConcurrency / 2
-	if newConcurrency < rl.minConcurrency {
-		newConcurrency = rl.minConcurrency
-	}
-	oldConcurrency := rl.currentConcurrency
-	rl.currentConcurrency = newConcurrency
-	rl.consecutiveOK = 0
-	cooldown := retryAfter
-	if cooldown < time.Duration(rl.cooldownSeconds)*time.Second {
-		cooldown = time.Duration(rl.cooldownSeconds) * time.Second
-	}
-	rl.cooldownUntil = now.Add(cooldown)
-	rl.cond.Broadcast()
-	log.Printf("429 rate limit: concurrency reduced %d -> %d, cooldown %.0fs", oldConcurrency, newConcurrency, cooldown.Seconds())
-	if newConcurrency == rl.minConcurrency {
-		log.Printf("429 rate limit: concurrency at minimum (%d worker); crawling at slowest pace", rl.minConcurrency)
-	}
-}
-
-func (rl *rateLimiter) onSuccess() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-	rl.consecutiveOK++
-	if rl.consecutiveOK >= rl.recoverAfter && rl.currentConcurrency < rl.maxConcurrency {
-		oldConcurrency := rl.currentConcurrency
-		rl.currentConcurrency++
-		rl.consecutiveOK = 0
-		log.Printf("429 rate limit: concurrency recovered %d -> %d", oldConcurrency, rl.currentConcurrency)
-	}
-}
-
-// parseRetryAfter parses the Retry-After header. Returns 0 if unparseable.
-func parseRetryAfter(hdr string, defaultSec int) time.Duration {
-	hdr = strings.TrimSpace(hdr)
-	if hdr == "" {
-		return time.Duration(defaultSec) * time.Second
-	}
-	if sec, err := strconv.Atoi(hdr); err == nil && sec >= 0 {
-		return time.Duration(sec) * time.Second
-	}
-	if t, err := time.Parse(time.RFC1123, hdr); err == nil {
-		d := time.Until(t)
-		if d > 0 {
-			return d
-		}
-	}
-	return time.Duration(defaultSec) * time.Second
-}
-
-// ============================
-// Cache Warmer
-// ============================
-
-type CacheWarmer struct {
-	cfg          Config
-	db           *WarmDB
-	client       *http.Client
-	rl           *rateLimiter
-	seenSitemaps map[string]bool
-	mu           sync.Mutex
-}
-
-func NewCacheWarmer(cfg Config, db *WarmDB) *CacheWarmer {
-	client := &http.Client{
-		Timeout: time.Duration(cfg.HTTP.TimeoutSeconds) * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= cfg.HTTP.MaxRedirects {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
-	}
-
-	cooldownSec := cfg.HTTP.RateLimitCooldownSeconds
-	if cooldownSec <= 0 {
-		cooldownSec = 120
-	}
-	recoverAfter := cfg.HTTP.RateLimitRecoverAfter
-	if recoverAfter <= 0 {
-		recoverAfter = 50
-	}
-	rl := newRateLimiter(cfg.HTTP.Concurrency, cooldownSec, recoverAfter)
-
-	return &CacheWarmer{
-		cfg:          cfg,
-		db:           db,
-		client:       client,
-		rl:           rl,
-		seenSitemaps: make(map[string]bool),
-	}
-}
-
-func (c *CacheWarmer) fetchBytes(ctx context.Context, url string) ([]byte, error) {
-	var lastErr error
-	cooldownSec := c.cfg.HTTP.RateLimitCooldownSeconds
-	if cooldownSec <= 0 {
-		cooldownSec = 120
-	}
-	max429Retries := c.cfg.HTTP.RateLimitMax429Retries
-	if max429Retries <= 0 {
-		max429Retries = 10
-	}
-	retries429 := 0
-
-	for attempt := 1; attempt <= c.cfg.HTTP.Retries+1; attempt++ {
-		if err := c.rl.acquire(ctx); err != nil {
-			return nil, err
-		}
-
-		if err := waitForLoad(ctx, c.cfg.Load); err != nil {
-			c.rl.release()
-			return nil, err
-		}
-
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			c.rl.release()
-			return nil, err
-		}
-		req.Header.Set("User-Agent", c.cfg.HTTP.UserAgent)
-
-		resp, err := c.client.Do(req)
-		if err != nil {
-			c.rl.release()
-			lastErr = err
-			if attempt >= c.cfg.HTTP.Retries+1 {
-				break
-			}
-			backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-			log.Printf("Fetch failed (%v) attempt %d/%d for %s; sleeping %.1fs",
-				err, attempt, c.cfg.HTTP.Retries+1, url, backoff.Seconds())
-			time.Sleep(backoff)
-			continue
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if err != nil {
-			c.rl.release()
-			lastErr = err
-			if attempt >= c.cfg.HTTP.Retries+1 {
-				break
-			}
-			backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-			time.Sleep(backoff)
-			continue
-		}
-
-		if resp.StatusCode == httpStatusTooMany {
-			retryAfter429 := parseRetryAfter(resp.Header.Get("Retry-After"), cooldownSec)
-			c.rl.on429(retryAfter429)
-			c.rl.release()
-			if retries429 >= max429Retries {
-				return nil, fmt.Errorf("429 Too Many Requests (exceeded %d retries)", max429Retries)
-			}
-			retries429++
-			log.Printf("429 for %s (retry %d/%d), cooling down %.0fs", url, retries429, max429Retries, retryAfter429.Seconds())
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			case <-time.After(retryAfter429):
-			}
-			attempt-- // Retry without counting against normal retry limit
-			continue
-		}
-
-		if resp.StatusCode >= httpStatusClientErr {
-			c.rl.release()
-			lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
-			if attempt >= c.cfg.HTTP.Retries+1 {
-				break
-			}
-			backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-			time.Sleep(backoff)
-			continue
-		}
-
-		c.rl.onSuccess()
-		c.rl.release()
-
-		// Decompress if .gz
-		if strings.HasSuffix(strings.ToLower(url), ".gz") {
-			reader, err := gzip.NewReader(strings.NewReader(string(body)))
-			if err != nil {
-				lastErr = fmt.Errorf("gzip.NewReader: %w", err)
-				if attempt >= c.cfg.HTTP.Retries+1 {
-					break
-				}
-				backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-				log.Printf("Gzip decompress failed for %s: %v; retrying in %.1fs", url, err, backoff.Seconds())
-				time.Sleep(backoff)
-				continue
-			}
-			decompressed, err := io.ReadAll(reader)
-			_ = reader.Close()
-			if err != nil {
-				lastErr = fmt.Errorf("gzip read: %w", err)
-				if attempt >= c.cfg.HTTP.Retries+1 {
-					break
-				}
-				backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-				log.Printf("Gzip decompress read failed for %s: %v; retrying in %.1fs", url, err, backoff.Seconds())
-				time.Sleep(backoff)
-				continue
-			}
-			body = decompressed
-		}
-
-		return body, nil
-	}
-
-	return nil, lastErr
-}
-
-func (c *CacheWarmer) collectURLsFromSitemap(ctx context.Context, sitemapURL string) ([]string, error) {
-	c.mu.Lock()
-	if c.seenSitemaps[sitemapURL] {
-		c.mu.Unlock()
-		return nil, nil
-	}
-	c.seenSitemaps[sitemapURL] = true
-	c.mu.Unlock()
-
-	log.Printf("Fetching sitemap: %s", sitemapURL)
-
-	data, err := c.fetchBytes(ctx, sitemapURL)
-	if err != nil {
-		c.db.MarkSitemap(sitemapURL, err.Error())
-		return nil, err
-	}
-
-	childSitemaps, urls, err := parseSitemapXML(data)
-	if err != nil {
-		c.db.MarkSitemap(sitemapURL, err.Error())
-		return nil, err
-	}
-
-	c.db.MarkSitemap(sitemapURL, "")
-
-	collected := urls
+// Build information, injected at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var version, commit, date string
 
-	for _, child := range childSitemaps {
-		select {
-		case <-ctx.Done():
-			return collected, ctx.Err()
-		default:
-		}
-
-		childURLs, err := c.collectURLsFromSitemap(ctx, child)
-		if err != nil {
-			log.Printf("Failed to fetch child sitemap %s: %v", child, err)
-			continue
-		}
-		collected = append(collected, childURLs...)
-	}
-
-	return collected, nil
-}
-
-// warmOne warms a single URL. Returns (status, errMsg, slotReleased).
-// If slotReleased is true, the caller must NOT call rl.release() — warmOne already did.
-func (c *CacheWarmer) warmOne(ctx context.Context, url string) (status int, errMsg string, slotReleased bool) {
-	if c.cfg.HTTP.MinDelayMS > 0 {
-		time.Sleep(time.Duration(c.cfg.HTTP.MinDelayMS) * time.Millisecond)
-	}
-
-	if err := waitForLoad(ctx, c.cfg.Load); err != nil {
-		return 0, err.Error(), false
-	}
-
-	cooldownSec := c.cfg.HTTP.RateLimitCooldownSeconds
-	if cooldownSec <= 0 {
-		cooldownSec = 120
-	}
-	max429Retries := c.cfg.HTTP.RateLimitMax429Retries
-	if max429Retries <= 0 {
-		max429Retries = 10
-	}
-
-	for retries429 := 0; retries429 < max429Retries; retries429++ {
-		select {
-		case <-ctx.Done():
-			return 0, ctx.Err().Error(), false
-		default:
-		}
-
-		var lastErr error
-		got429 := false
-		var retryAfter429 time.Duration
-
-		for attempt := 1; attempt <= c.cfg.HTTP.Retries+1; attempt++ {
-			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-			if err != nil {
-				return 0, err.Error(), false
-			}
-			req.Header.Set("User-Agent", c.cfg.HTTP.UserAgent)
-
-			resp, err := c.client.Do(req)
-			if err != nil {
-				lastErr = err
-				if attempt >= c.cfg.HTTP.Retries+1 {
-					break
-				}
-				backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-				log.Printf("Warm failed (%v) attempt %d/%d for %s; sleeping %.1fs",
-					err, attempt, c.cfg.HTTP.Retries+1, url, backoff.Seconds())
-				time.Sleep(backoff)
-				continue
-			}
-
-			// Read full body to warm cache
-			_, err = io.Copy(io.Discard, resp.Body)
-			resp.Body.Close()
-
-			if err != nil {
-				lastErr = err
-				if attempt >= c.cfg.HTTP.Retries+1 {
-					break
-				}
-				backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-				time.Sleep(backoff)
-				continue
-			}
-
-			if resp.StatusCode == httpStatusTooMany {
-				retryAfter429 = parseRetryAfter(resp.Header.Get("Retry-After"), cooldownSec)
-				c.rl.on429(retryAfter429)
-				log.Printf("429 Too Many Requests for %s -- reducing concurrency, cooling down %.0fs; will retry",
-					url, retryAfter429.Seconds())
-				got429 = true
-				break
-			}
-
-			if resp.StatusCode >= httpStatusClientErr {
-				lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
-				if attempt >= c.cfg.HTTP.Retries+1 {
-					return resp.StatusCode, lastErr.Error(), false
-				}
-				backoff := time.Duration(float64(attempt)*c.cfg.HTTP.RetryBackoffSeconds) * time.Second
-				time.Sleep(backoff)
-				continue
-			}
-
-			c.rl.onSuccess()
-			return resp.StatusCode, "", false
-		}
-
-		if got429 {
-			// Release slot before cooldown to restore invariant activeWorkers <= currentConcurrency.
-			// Otherwise we could have activeWorkers=8 and currentConcurrency=4, starving new workers.
-			c.rl.release()
-			if retries429 >= max429Retries-1 {
-				// Exhausted 429 retries; treat as permanent failure
-				return httpStatusTooMany,
-					fmt.Sprintf("429 Too Many Requests (exceeded %d retries)", max429Retries), true
-			}
-			select {
-			case <-ctx.Done():
-				// Caller must not release again — we already did.
-				return 0, ctx.Err().Error(), true
-			case <-time.After(retryAfter429):
-			}
-			if err := c.rl.acquire(ctx); err != nil {
-				// Caller must not release again — we already did before cooldown.
-				return 0, err.Error(), true
-			}
-			continue
-		}
-		if lastErr != nil {
-			return 0, lastErr.Error(), false
-		}
-		return 0, "unreachable", false
-	}
-	// Exhausted 429 retries without getting past the got429 block (should not reach)
-	return httpStatusTooMany, fmt.Sprintf("429 Too Many Requests (exceeded %d retries)", max429Retries), false
-}
-
-func (c *CacheWarmer) runOnce(ctx context.Context) (int, int, error) {
-	c.seenSitemaps = make(map[string]bool)
-
-	// Collect URLs
-	var allURLs []string
-	for _, sm := range c.cfg.Sitemaps.URLs {
-		select {
-		case <-ctx.Done():
-			return 0, 0, ctx.Err()
-		default:
-		}
-
-		urls, err := c.collectURLsFromSitemap(ctx, sm)
-		if err != nil {
-			log.Printf("Error collecting from sitemap %s: %v", sm, err)
-		}
-		allURLs = append(allURLs, urls...)
-	}
-
-	// De-duplicate
-	seen := make(map[string]bool)
-	var uniqueURLs []string
-	for _, u := range allURLs {
-		if u == "" || seen[u] {
-			continue
-		}
-		seen[u] = true
-		uniqueURLs = append(uniqueURLs, u)
-	}
-
-	log.Printf("Collected %d unique URLs from sitemaps.", len(uniqueURLs))
-
-	// Filter URLs that need warming
-	rewarmAfter := time.Duration(c.cfg.App.RewarmAfterHours) * time.Hour
-	var toWarm []string
-	for _, u := range uniqueURLs {
-		shouldWarm, err := c.db.ShouldWarm(u, rewarmAfter)
-		if err != nil {
-			log.Printf("Error checking if should warm %s: %v", u, err)
-			continue
-		}
-		if shouldWarm {
-			toWarm = append(toWarm, u)
-		}
-	}
-
-	log.Printf("Need to warm %d URLs (rewarm_after=%dh).", len(toWarm), c.cfg.App.RewarmAfterHours)
-
-	// Warm concurrently (atomic counters to avoid race conditions)
-	var ok, fail atomic.Int64
-	var wg sync.WaitGroup
-
-	for _, url := range toWarm {
-		select {
-		case <-ctx.Done():
-			wg.Wait()
-			return int(ok.Load()), int(fail.Load()), ctx.Err()
-		default:
-		}
-
-		wg.Add(1)
-		go func(u string) {
-			defer wg.Done()
-
-			if err := c.rl.acquire(ctx); err != nil {
-				log.Printf("WARM SKIP %s (context cancelled)", u)
-				return
-			}
-			var slotReleased bool
-			defer func() {
-				if !slotReleased {
-					c.rl.release()
-				}
-			}()
-
-			status, errMsg, slotReleased := c.warmOne(ctx, u)
-			c.db.MarkWarmed(u, status, errMsg)
-
-			if errMsg != "" {
-				fail.Add(1)
-				log.Printf("WARM FAIL %s error=%s", u, errMsg)
-			} else {
-				ok.Add(1)
-				log.Printf("WARM OK   %s status=%d", u, status)
-			}
-		}(url)
-	}
-
-	wg.Wait()
-
-	okVal, failVal := ok.Load(), fail.Load()
-	log.Printf("Run complete. ok=%d fail=%d", okVal, failVal)
-	return int(okVal), int(failVal), nil
-}
-
-func (c *CacheWarmer) runLoop(ctx context.Context) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		_, _, err := c.runOnce(ctx)
-		if err != nil && err != context.Canceled {
-			log.Printf("Error during run: %v", err)
-		}
-
-		if !c.cfg.App.Loop {
-			return nil
-		}
-
-		log.Printf("Sleeping for %d seconds before next run...", c.cfg.App.LoopIntervalSeconds)
-
-		select {
-		case <-time.After(time.Duration(c.cfg.App.LoopIntervalSeconds) * time.Second):
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
-}
+// Display truncation limits for status output
+const (
+	truncateURLLong      = 50
+	truncateURLShort     = 45
+	truncateURLSitemap   = 55
+	truncateErrorMsg     = 30
+	maxTimestampDisplay  = 19
+)
 
 // ============================
 // CLI Commands
@@ -1126,7 +60,7 @@ func cmdInit(configPath string, force bool) error {
 	}
 
 	// Replace max_load in template with calculated value
-	config := strings.Replace(defaultConfigTOML, "max_load = 2.0", fmt.Sprintf("max_load = %.1f", maxLoad), 1)
+	config := strings.Replace(warmer.DefaultConfigTOML, "max_load = 2.0", fmt.Sprintf("max_load = %.1f", maxLoad), 1)
 
 	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
 		return err
@@ -1151,22 +85,88 @@ func truncateTimestamp(s string) string {
 	return s
 }
 
-func statusPrintStatistics(stats *Stats, yellow, _ func(a ...interface{}) string) {
-	fmt.Println("\n📊", yellow("STATISTICS"))
-	fmt.Println(strings.Repeat("-", 70))
-	fmt.Printf("  Total URLs Warmed:    %d\n", stats.WarmedTotal)
-	fmt.Printf("  Successful (2xx-3xx): %d\n", stats.OKTotal)
-	fmt.Printf("  Failed (4xx-5xx):     %d\n", stats.ErrTotal)
+func statusPrintStatistics(w io.Writer, stats *warmer.Stats, yellow, _ func(a ...interface{}) string) {
+	fmt.Fprintln(w, "\n📊", yellow("STATISTICS"))
+	fmt.Fprintln(w, strings.Repeat("-", 70))
+	fmt.Fprintf(w, "  Total URLs Warmed:    %d\n", stats.WarmedTotal)
+	fmt.Fprintf(w, "  Successful (2xx-3xx): %d\n", stats.OKTotal)
+	fmt.Fprintf(w, "  Failed (4xx-5xx):     %d\n", stats.ErrTotal)
+	for _, class := range warmer.ErrorClassOrder {
+		if n := stats.ErrByClass[class]; n > 0 {
+			fmt.Fprintf(w, "    %-19s %d\n", class+":", n)
+		}
+	}
 	if stats.LastFlushUTC != "" {
-		fmt.Printf("  Last Cache Flush:     %s\n", stats.LastFlushUTC)
+		fmt.Fprintf(w, "  Last Cache Flush:     %s\n", stats.LastFlushUTC)
 	} else {
-		fmt.Printf("  Last Cache Flush:     Never\n")
+		fmt.Fprintf(w, "  Last Cache Flush:     Never\n")
 	}
 }
 
-func statusPrintRecentURLs(db *WarmDB, limit int, green, red, yellow func(a ...interface{}) string) error {
-	fmt.Printf("\n✅ %s (%d most recent)\n", yellow("RECENTLY WARMED"), limit)
-	fmt.Println(strings.Repeat("-", 70))
+func statusPrintStatusCodes(w io.Writer, db *warmer.WarmDB, successCodes []int, green, red, yellow func(a ...interface{}) string) error {
+	fmt.Fprintln(w, "\n🔢", yellow("STATUS CODES"))
+	fmt.Fprintln(w, strings.Repeat("-", 70))
+	hist, err := db.StatusHistogram()
+	if err != nil {
+		return err
+	}
+	if len(hist) == 0 {
+		fmt.Fprintln(w, "  (No URLs warmed yet)")
+		return nil
+	}
+
+	codes := make([]int, 0, len(hist))
+	total := 0
+	for code, n := range hist {
+		codes = append(codes, code)
+		total += n
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		label := strconv.Itoa(code)
+		if code == 0 {
+			label = "error"
+		}
+		label = fmt.Sprintf("%-6s", label)
+		if warmer.IsSuccessStatus(code, successCodes) {
+			label = green(label)
+		} else {
+			label = red(label)
+		}
+		n := hist[code]
+		fmt.Fprintf(w, "  %s %8d  %5.1f%%\n", label, n, float64(n)*100/float64(total))
+	}
+	return nil
+}
+
+func statusPrintLimiter(w io.Writer, db *warmer.WarmDB, yellow, red func(a ...interface{}) string) error {
+	fmt.Fprintln(w, "\n🎚️ ", yellow("RATE LIMITER"))
+	fmt.Fprintln(w, strings.Repeat("-", 70))
+	st, err := db.GetLimiterStatus()
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		fmt.Fprintln(w, "  (No run has recorded limiter state yet)")
+		return nil
+	}
+	current := fmt.Sprintf("%d", st.Current)
+	if st.Current < st.Max {
+		current = red(current)
+	}
+	fmt.Fprintf(w, "  Current concurrency:  %s (max %d, min %d)\n", current, st.Max, st.Min)
+	if st.CoolingHosts > 0 && time.Now().Before(st.CooldownUntil) {
+		fmt.Fprintf(w, "  429 cooldown:         %d host(s) until %s\n", st.CoolingHosts, st.CooldownUntil.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(w, "  429 cooldown:         none\n")
+	}
+	fmt.Fprintf(w, "  Updated:              %s\n", truncateTimestamp(st.UpdatedUTC))
+	return nil
+}
+
+func statusPrintRecentURLs(w io.Writer, db *warmer.WarmDB, limit int, successCodes []int, green, red, yellow func(a ...interface{}) string) error {
+	fmt.Fprintf(w, "\n✅ %s (%d most recent)\n", yellow("RECENTLY WARMED"), limit)
+	fmt.Fprintln(w, strings.Repeat("-", 70))
 	recent, err := db.GetRecentWarmed(limit)
 	if err != nil {
 		return err
@@ -1174,22 +174,22 @@ func statusPrintRecentURLs(db *WarmDB, limit int, green, red, yellow func(a ...i
 	if len(recent) > 0 {
 		for _, r := range recent {
 			icon := green("✅")
-			if r.Status >= httpStatusClientErr {
+			if !warmer.IsSuccessStatus(r.Status, successCodes) {
 				icon = red("❌")
 			}
 			displayURL := truncate(r.URL, truncateURLLong)
 			ts := truncateTimestamp(r.Timestamp)
-			fmt.Printf("  %s [%d] %s | %s\n", icon, r.Status, ts, displayURL)
+			fmt.Fprintf(w, "  %s [%d] %s | %s\n", icon, r.Status, ts, displayURL)
 		}
 	} else {
-		fmt.Println("  (No URLs warmed yet)")
+		fmt.Fprintln(w, "  (No URLs warmed yet)")
 	}
 	return nil
 }
 
-func statusPrintFailures(db *WarmDB, limit int, red, yellow func(a ...interface{}) string) error {
-	fmt.Printf("\n❌ %s (%d most recent)\n", yellow("RECENT FAILURES"), limit)
-	fmt.Println(strings.Repeat("-", 70))
+func statusPrintFailures(w io.Writer, db *warmer.WarmDB, limit int, red, yellow func(a ...interface{}) string) error {
+	fmt.Fprintf(w, "\n❌ %s (%d most recent)\n", yellow("RECENT FAILURES"), limit)
+	fmt.Fprintln(w, strings.Repeat("-", 70))
 	failed, err := db.GetFailedURLs(limit)
 	if err != nil {
 		return err
@@ -1202,19 +202,25 @@ func statusPrintFailures(db *WarmDB, limit int, red, yellow func(a ...interface{
 			if f.Error.Valid {
 				errorMsg = truncate(f.Error.String, truncateErrorMsg)
 			}
-			fmt.Printf("  %s [%d] %s\n", red("❌"), f.Status, ts)
-			fmt.Printf("     URL: %s\n", displayURL)
-			fmt.Printf("     Error: %s\n", errorMsg)
+			fmt.Fprintf(w, "  %s [%d] %s\n", red("❌"), f.Status, ts)
+			fmt.Fprintf(w, "     URL: %s\n", displayURL)
+			fmt.Fprintf(w, "     Error: %s\n", errorMsg)
+			if f.FirstSeen.Valid {
+				fmt.Fprintf(w, "     First seen: %s\n", truncateTimestamp(f.FirstSeen.String))
+			}
+			if f.Source.Valid {
+				fmt.Fprintf(w, "     Sitemap: %s\n", truncate(f.Source.String, truncateURLSitemap))
+			}
 		}
 	} else {
-		fmt.Println("  (No failures)")
+		fmt.Fprintln(w, "  (No failures)")
 	}
 	return nil
 }
 
-func statusPrintSitemaps(db *WarmDB, green, red, yellow func(a ...interface{}) string) error {
-	fmt.Printf("\n🗺️  %s\n", yellow("SITEMAP STATUS"))
-	fmt.Println(strings.Repeat("-", 70))
+func statusPrintSitemaps(w io.Writer, db *warmer.WarmDB, green, red, yellow func(a ...interface{}) string) error {
+	fmt.Fprintf(w, "\n🗺️  %s\n", yellow("SITEMAP STATUS"))
+	fmt.Fprintln(w, strings.Repeat("-", 70))
 	sitemaps, err := db.GetSitemapStatus()
 	if err != nil {
 		return err
@@ -1224,75 +230,288 @@ func statusPrintSitemaps(db *WarmDB, green, red, yellow func(a ...interface{}) s
 			icon := green("✅")
 			if sm.Error.Valid && sm.Error.String != "" {
 				icon = red("❌")
+			} else if sm.WentEmpty() {
+				icon = yellow("⚠️ ")
 			}
 			displayURL := truncate(sm.URL, truncateURLSitemap)
 			ts := truncateTimestamp(sm.Timestamp)
-			fmt.Printf("  %s %s | %s\n", icon, ts, displayURL)
+			fmt.Fprintf(w, "  %s %s | %s\n", icon, ts, displayURL)
 			if sm.Error.Valid && sm.Error.String != "" {
-				fmt.Printf("     Error: %s\n", sm.Error.String)
+				fmt.Fprintf(w, "     Error: %s\n", sm.Error.String)
+			} else if sm.WentEmpty() {
+				fmt.Fprintf(w, "     Warning: returned 0 URLs (previously up to %d)\n", sm.MaxURLCount)
 			}
 		}
 	} else {
-		fmt.Println("  (No sitemaps fetched yet)")
+		fmt.Fprintln(w, "  (No sitemaps fetched yet)")
 	}
 	return nil
 }
 
-func cmdStatus(configPath string, showRecent, showFailed int) error {
-	cfg, err := loadConfig(configPath)
+func cmdStatus(configPath, site, dbPath, output string, showRecent, showFailed int) error {
+	cfg, err := loadSiteConfig(configPath, site)
 	if err != nil {
 		return err
 	}
+	if dbPath != "" {
+		cfg.App.DBPath = dbPath
+	}
 
-	db, err := NewWarmDB(cfg.App.DBPath)
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
+	db.SetSuccessStatusCodes(cfg.HTTP.SuccessStatusCodes)
 
 	stats, err := db.Stats()
 	if err != nil {
 		return err
 	}
 
+	// Render into a buffer for -output so the file is replaced in one go;
+	// escape codes make no sense in a file, so color is disabled there.
+	var w io.Writer = os.Stdout
+	var buf bytes.Buffer
+	if output != "" {
+		color.NoColor = true
+		w = &buf
+	}
+
 	cyan := color.New(color.FgCyan).SprintFunc()
 	green := color.New(color.FgGreen).SprintFunc()
 	red := color.New(color.FgRed).SprintFunc()
 	yellow := color.New(color.FgYellow).SprintFunc()
 
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 70))
+	fmt.Fprintln(w, "  ", cyan("CACHE WARMER DASHBOARD"))
+	fmt.Fprintln(w, strings.Repeat("=", 70))
+
+	statusPrintStatistics(w, stats, yellow, green)
+	if err := statusPrintStatusCodes(w, db, cfg.HTTP.SuccessStatusCodes, green, red, yellow); err != nil {
+		return err
+	}
+	if err := statusPrintLimiter(w, db, yellow, red); err != nil {
+		return err
+	}
+	if err := statusPrintRecentURLs(w, db, showRecent, cfg.HTTP.SuccessStatusCodes, green, red, yellow); err != nil {
+		return err
+	}
+	if err := statusPrintFailures(w, db, showFailed, red, yellow); err != nil {
+		return err
+	}
+	if err := statusPrintSitemaps(w, db, green, red, yellow); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.Repeat("=", 70))
+	fmt.Fprintf(w, "  Config: %s\n", configPath)
+	if v, err := db.SchemaVersion(); err == nil {
+		fmt.Fprintf(w, "  Database: %s (schema v%d)\n", cfg.App.DBPath, v)
+	} else {
+		fmt.Fprintf(w, "  Database: %s\n", cfg.App.DBPath)
+	}
+	fmt.Fprintln(w, strings.Repeat("=", 70))
+	fmt.Fprintln(w)
+
+	if output != "" {
+		return warmer.WriteFileAtomic(output, buf.Bytes())
+	}
+	return nil
+}
+
+// cmdStats prints only the statistics block of the dashboard, compact enough
+// for cron emails.
+func cmdStats(configPath, site string) error {
+	cfg, err := loadSiteConfig(configPath, site)
+	if err != nil {
+		return err
+	}
+
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetSuccessStatusCodes(cfg.HTTP.SuccessStatusCodes)
+
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	statusPrintStatistics(os.Stdout, stats, yellow, green)
+	fmt.Println()
+	return nil
+}
+
+func cmdHistory(configPath, site string, limit int) error {
+	cfg, err := loadSiteConfig(configPath, site)
+	if err != nil {
+		return err
+	}
+
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runs, err := db.GetRunHistory(limit)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println("  ", cyan("CACHE WARMER RUN HISTORY"))
+	fmt.Println(strings.Repeat("=", 70))
+
+	fmt.Printf("\n🕒 %s (%d most recent)\n", yellow("RUNS"), limit)
+	fmt.Println(strings.Repeat("-", 70))
+	if len(runs) > 0 {
+		fmt.Printf("  %-8s %-19s %9s %9s %7s %7s %6s %10s\n", "Run", "Started", "Duration", "Collected", "Warmed", "OK", "Fail", "Bytes")
+		for _, r := range runs {
+			duration := "-"
+			start, err1 := time.Parse(time.RFC3339, r.StartedUTC)
+			end, err2 := time.Parse(time.RFC3339, r.FinishedUTC)
+			if err1 == nil && err2 == nil {
+				duration = end.Sub(start).String()
+			}
+			note := ""
+			if r.Interrupted {
+				note = "  (interrupted)"
+			}
+			runID := r.RunID
+			if runID == "" {
+				runID = "-"
+			}
+			fmt.Printf("  %-8s %-19s %9s %9d %7d %7d %6d %10s%s\n", runID, truncateTimestamp(r.StartedUTC), duration,
+				r.URLsCollected, r.URLsWarmed, r.OK, r.Fail, warmer.FormatBytes(r.Bytes), note)
+		}
+	} else {
+		fmt.Println("  (No runs recorded yet)")
+	}
+
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println()
+
+	return nil
+}
+
+func cmdTop(configPath, site, by string, limit int) error {
+	if by != "count" && by != "failures" {
+		return fmt.Errorf("-by must be \"count\" or \"failures\", got %q", by)
+	}
+	cfg, err := loadSiteConfig(configPath, site)
+	if err != nil {
+		return err
+	}
+
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetSuccessStatusCodes(cfg.HTTP.SuccessStatusCodes)
+
+	var urls []warmer.TopURL
+	title, column := "MOST WARMED", "Warms"
+	if by == "failures" {
+		title, column = "MOST FAILED", "Fails"
+		urls, err = db.GetMostFailed(limit)
+	} else {
+		urls, err = db.GetMostWarmed(limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
 	fmt.Println()
 	fmt.Println(strings.Repeat("=", 70))
-	fmt.Println("  ", cyan("CACHE WARMER DASHBOARD"))
+	fmt.Println("  ", cyan("CACHE WARMER TOP URLS"))
+	fmt.Println(strings.Repeat("=", 70))
+
+	fmt.Printf("\n🏆 %s (top %d)\n", yellow(title), limit)
+	fmt.Println(strings.Repeat("-", 70))
+	if by == "count" && cfg.App.TrackWarmCount != nil && !*cfg.App.TrackWarmCount {
+		fmt.Println("  Note: app.track_warm_count = false, so counts stop at the first warm")
+	}
+	if len(urls) > 0 {
+		fmt.Printf("  %4s %6s %6s  %-19s  %s\n", "#", column, "Status", "Last warmed", "URL")
+		for i, u := range urls {
+			fmt.Printf("  %4d %6d %6d  %-19s  %s\n", i+1, u.Count, u.Status, truncateTimestamp(u.Timestamp), u.URL)
+			if by == "failures" && u.Error.Valid {
+				fmt.Printf("  %4s %6s %6s  %-19s  %s\n", "", "", "", "", red(u.Error.String))
+			}
+		}
+	} else if by == "failures" {
+		fmt.Println("  (No failures)")
+	} else {
+		fmt.Println("  (No URLs warmed yet)")
+	}
+
+	fmt.Println()
 	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println()
+
+	return nil
+}
 
-	statusPrintStatistics(stats, yellow, green)
-	if err := statusPrintRecentURLs(db, showRecent, green, red, yellow); err != nil {
+// cmdList prints one tab-separated line per tracked URL, for grep and cut:
+// last status, last warm, first seen, URL and the sitemap it was first
+// collected from ("-" for [warm] extra_urls and crawled URLs).
+func cmdList(configPath, site string, limit int) error {
+	cfg, err := loadSiteConfig(configPath, site)
+	if err != nil {
 		return err
 	}
-	if err := statusPrintFailures(db, showFailed, red, yellow); err != nil {
+
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
 		return err
 	}
-	if err := statusPrintSitemaps(db, green, red, yellow); err != nil {
+	defer db.Close()
+
+	urls, err := db.ListURLs(limit)
+	if err != nil {
 		return err
 	}
 
-	fmt.Println()
-	fmt.Println(strings.Repeat("=", 70))
-	fmt.Printf("  Config: %s\n", configPath)
-	fmt.Printf("  Database: %s\n", cfg.App.DBPath)
-	fmt.Println(strings.Repeat("=", 70))
-	fmt.Println()
-
+	fmt.Println("status\tlast_warmed_utc\tfirst_seen_utc\turl\tsource_sitemap")
+	for _, u := range urls {
+		firstSeen := "-"
+		if u.FirstSeen.Valid && u.FirstSeen.String != "" {
+			firstSeen = u.FirstSeen.String
+		}
+		source := "-"
+		if u.Source.Valid && u.Source.String != "" {
+			source = u.Source.String
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", u.Status, u.Timestamp, firstSeen, u.URL, source)
+	}
 	return nil
 }
 
-func cmdFlush(configPath string, reason string) error {
-	cfg, err := loadConfig(configPath)
+func cmdFlush(configPath, site string, reason string, now bool) error {
+	cfg, err := loadSiteConfig(configPath, site)
 	if err != nil {
 		return err
 	}
 
-	db, err := NewWarmDB(cfg.App.DBPath)
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
 	if err != nil {
 		return err
 	}
@@ -1323,18 +542,68 @@ func cmdFlush(configPath string, reason string) error {
 
 	fmt.Printf("\n  📊 Current Stats:\n")
 	fmt.Printf("     Total URLs warmed: %s\n", cyan(fmt.Sprint(stats.WarmedTotal)))
-	fmt.Printf("     %s\n", green("Will be re-warmed on next run!"))
+	if now {
+		fmt.Printf("     %s\n", green("Re-warming now..."))
+	} else {
+		fmt.Printf("     %s\n", green("Will be re-warmed on next run!"))
+	}
 	fmt.Println()
 	fmt.Println(strings.Repeat("=", 70))
 	fmt.Println()
 
 	log.Printf("Marked cache flush. reason=%s", reason)
 
+	if now {
+		// Warm in this process; a running "run" service may already own the
+		// health endpoint address.
+		return cmdRun(configPath, true, runOptions{Site: site, skipHealth: true})
+	}
 	return nil
 }
 
-func cmdRun(configPath string, once bool) error {
-	cfg, err := loadConfig(configPath)
+func cmdReset(configPath, site string, confirm, all bool) error {
+	if !confirm {
+		return fmt.Errorf("reset deletes all warm history; re-run with -confirm to proceed")
+	}
+
+	cfg, err := loadSiteConfig(configPath, site)
+	if err != nil {
+		return err
+	}
+
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Reset(all); err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println("  ", green("✅ DATABASE RESET"))
+	fmt.Println(strings.Repeat("=", 70))
+	if all {
+		fmt.Printf("\n  Cleared: warmed URLs, sitemaps, flush metadata, run history\n")
+	} else {
+		fmt.Printf("\n  Cleared: warmed URLs, sitemaps (flush metadata and run history kept)\n")
+	}
+	fmt.Printf("  Database: %s\n", cfg.App.DBPath)
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println()
+
+	log.Printf("Database reset. all=%t", all)
+
+	return nil
+}
+
+func cmdRun(configPath string, once bool, opts runOptions) error {
+	cfg, err := warmer.ReadConfig(configPath)
 	if err != nil {
 		return err
 	}
@@ -1343,54 +612,59 @@ func cmdRun(configPath string, once bool) error {
 	if cfg.App.LogFile != "" {
 		logDir := filepath.Dir(cfg.App.LogFile)
 		if err := os.MkdirAll(logDir, 0755); err != nil {
-			return err
+			return fmt.Errorf("app.log_file %s is not writable: %w", cfg.App.LogFile, err)
 		}
 
 		f, err := os.OpenFile(cfg.App.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 		if err != nil {
-			return err
+			return fmt.Errorf("app.log_file %s is not writable: %w", cfg.App.LogFile, err)
 		}
 		defer f.Close()
 
 		log.SetOutput(io.MultiWriter(os.Stdout, f))
 	}
 
-	db, err := NewWarmDB(cfg.App.DBPath)
+	ropts := opts.runnerOptions()
+	if profiles, err := cfg.SelectSites(ropts.Site); err == nil && ropts.DBPath != "" && len(profiles) > 1 {
+		return fmt.Errorf("-db / %s needs a single site; choose one with -site", dbEnvVar)
+	}
+	runner, err := warmer.NewRunner(configPath, cfg, ropts)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer runner.Close()
 
-	warmer := NewCacheWarmer(cfg, db)
+	if len(opts.Sitemaps) > 0 {
+		log.Printf("Using %d sitemap(s) from -sitemap instead of the configured sitemaps.", len(opts.Sitemaps))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		log.Println("Received stop signal, shutting down...")
-		cancel()
-	}()
+	// SIGHUP reloads the config before the next loop iteration
+	handleRunSignals(ctx, cancel, once, runner)
 
-	if once {
-		log.Printf("Starting cache warmer ONCE. db=%s concurrency=%d max_load=%.2f",
-			cfg.App.DBPath, cfg.HTTP.Concurrency, cfg.Load.MaxLoad)
-		ok, fail, err := warmer.runOnce(ctx)
-		if err != nil && err != context.Canceled {
+	if cfg.Health.Listen != "" && !opts.skipHealth {
+		if err := warmer.ServeHealth(ctx, cfg.Health.Listen, runner); err != nil {
 			return err
 		}
+	}
 
-		stats, _ := db.Stats()
-		log.Printf("Summary: ok=%d fail=%d warmed_total=%d last_flush_utc=%s",
-			ok, fail, stats.WarmedTotal, stats.LastFlushUTC)
+	if once {
+		if err := runner.RunOnce(ctx); err != nil {
+			return err
+		}
 	} else {
-		log.Printf("Starting cache warmer LOOP=%t interval=%ds db=%s concurrency=%d max_load=%.2f",
-			cfg.App.Loop, cfg.App.LoopIntervalSeconds, cfg.App.DBPath,
-			cfg.HTTP.Concurrency, cfg.Load.MaxLoad)
-		if err := warmer.runLoop(ctx); err != nil && err != context.Canceled {
+		if ws := runner.Warmers(); len(ws) == 1 && ws[0].Site() == "" {
+			wc := ws[0].Config()
+			log.Printf("Starting cache warmer LOOP=%t interval=%ds db=%s concurrency=%d max_load=%.2f",
+				wc.App.Loop, wc.App.LoopIntervalSeconds, wc.App.DBPath,
+				wc.HTTP.Concurrency, wc.Load.MaxLoad)
+		} else {
+			log.Printf("Starting cache warmer LOOP=%t interval=%ds sites=%d max_load=%.2f",
+				cfg.App.Loop, cfg.App.LoopIntervalSeconds, len(ws), cfg.Load.MaxLoad)
+		}
+		if err := runner.Run(ctx); err != nil && err != context.Canceled {
 			return err
 		}
 	}
@@ -1399,127 +673,487 @@ func cmdRun(configPath string, once bool) error {
 	return nil
 }
 
-// ============================
-// Config Loading
-// ============================
+// cmdRunDir loads every *.toml in dir and runs them concurrently in one
+// process, each with its own databases and rate limiters but one shared
+// LoadGate using the lowest max_load of all configs. Logs go to stdout;
+// app.log_file is not used. [health] is served from the first config that
+// sets a listen address and covers all configs.
+func cmdRunDir(dir string, once bool, opts runOptions) error {
+	if opts.set["config"] {
+		return fmt.Errorf("use either -config or -config-dir, not both")
+	}
+	if opts.DBPath != "" || os.Getenv(dbEnvVar) != "" {
+		return fmt.Errorf("-db / %s cannot be used with -config-dir; each config uses its own db_path", dbEnvVar)
+	}
 
-// validateConfig checks config values and returns descriptive errors.
-func validateConfig(cfg *Config) error {
-	// HTTP validation
-	if cfg.HTTP.Concurrency < 1 {
-		return fmt.Errorf("http.concurrency must be >= 1, got %d", cfg.HTTP.Concurrency)
+	paths, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return err
 	}
-	if cfg.HTTP.TimeoutSeconds < 1 {
-		return fmt.Errorf("http.timeout_seconds must be > 0, got %d", cfg.HTTP.TimeoutSeconds)
+	if len(paths) == 0 {
+		return fmt.Errorf("no *.toml configs found in %s", dir)
 	}
-	if cfg.HTTP.ConnectTimeoutSeconds < 1 {
-		return fmt.Errorf("http.connect_timeout_seconds must be > 0, got %d", cfg.HTTP.ConnectTimeoutSeconds)
+
+	runners := make([]*warmer.Runner, len(paths))
+	names := make([]string, len(paths))
+	sites := 0
+	healthListen := ""
+	for i, path := range paths {
+		cfg, err := warmer.ReadConfig(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		names[i] = strings.TrimSuffix(filepath.Base(path), ".toml")
+		ropts := opts.runnerOptions()
+		ropts.Name = names[i]
+		runners[i], err = warmer.NewRunner(path, cfg, ropts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer runners[i].Close()
+
+		sites += len(runners[i].Warmers())
+		if healthListen == "" {
+			healthListen = cfg.Health.Listen
+		}
 	}
-	if cfg.HTTP.MaxRedirects < 0 {
-		return fmt.Errorf("http.max_redirects must be >= 0, got %d", cfg.HTTP.MaxRedirects)
+
+	// One load gate for all configs, so together they respect one max_load
+	load := warmer.ShareLoadGate(runners...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handleRunSignals(ctx, cancel, once, runners...)
+
+	if healthListen != "" && !opts.skipHealth {
+		if err := warmer.ServeHealth(ctx, healthListen, runners...); err != nil {
+			return err
+		}
 	}
-	if cfg.HTTP.MinDelayMS < 0 {
-		return fmt.Errorf("http.min_delay_ms must be >= 0, got %d", cfg.HTTP.MinDelayMS)
+
+	log.Printf("Starting cache warmer for %d configs from %s (once=%t, sites=%d, max_load=%.2f)",
+		len(runners), dir, once, sites, load.MaxLoad)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(runners))
+	for i, runner := range runners {
+		wg.Add(1)
+		go func(i int, runner *warmer.Runner) {
+			defer wg.Done()
+			if once {
+				errs[i] = runner.RunOnce(ctx)
+			} else if err := runner.Run(ctx); err != nil && err != context.Canceled {
+				errs[i] = err
+			}
+		}(i, runner)
 	}
-	if cfg.HTTP.Retries < 0 {
-		return fmt.Errorf("http.retries must be >= 0, got %d", cfg.HTTP.Retries)
+	wg.Wait()
+
+	var failedSites []string
+	for i, err := range errs {
+		var runErr *warmer.RunFailedError
+		switch {
+		case err == nil:
+		case errors.As(err, &runErr):
+			failedSites = append(failedSites, runErr.Sites...)
+		default:
+			return fmt.Errorf("%s: %w", names[i], err)
+		}
 	}
-	if cfg.HTTP.RetryBackoffSeconds < 0 {
-		return fmt.Errorf("http.retry_backoff_seconds must be >= 0, got %f", cfg.HTTP.RetryBackoffSeconds)
+	if len(failedSites) > 0 {
+		return &warmer.RunFailedError{Sites: failedSites}
 	}
-	if cfg.HTTP.RateLimitMax429Retries < 0 {
-		return fmt.Errorf("http.rate_limit_max_429_retries must be >= 0, got %d", cfg.HTTP.RateLimitMax429Retries)
+
+	log.Println("Stopped.")
+	return nil
+}
+
+// handleRunSignals cancels ctx on SIGINT/SIGTERM and, outside once mode,
+// queues a reload on every runner for SIGHUP.
+func handleRunSignals(ctx context.Context, cancel context.CancelFunc, once bool, runners ...*warmer.Runner) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Received stop signal, shutting down...")
+		cancel()
+	}()
+
+	if once {
+		return
 	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hupChan)
+		for {
+			select {
+			case <-hupChan:
+				log.Println("Received SIGHUP, reloading config before the next run...")
+				for _, r := range runners {
+					r.Reload()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// exitRunFailed is the exit code for a run that completed but mostly failed,
+// distinct from 1 (configuration or setup error).
+const exitRunFailed = 2
+
+// configFlagUsage documents -config. Only when it is left at its default does
+// CACHE_WARMER_CONFIG take effect.
+const configFlagUsage = "Path to config TOML (\"-\" reads stdin; default from $" + warmer.ConfigEnvVar + " if set)"
+
+// dbEnvVar overrides app.db_path (or the selected site's db_path), e.g. for a
+// throwaway database per CI job. A -db flag takes precedence.
+const dbEnvVar = "CACHE_WARMER_DB"
 
-	// App validation
-	if cfg.App.RewarmAfterHours < 1 {
-		return fmt.Errorf("app.rewarm_after_hours must be >= 1, got %d", cfg.App.RewarmAfterHours)
+// loadConfig reads the config for commands other than run/once, which take
+// -sitemap and so cannot require configured sitemaps: every site must have
+// something to warm.
+func loadConfig(configPath string) (warmer.Config, error) {
+	cfg, err := warmer.ReadConfig(configPath)
+	if err != nil {
+		return cfg, err
 	}
-	if cfg.App.Loop && cfg.App.LoopIntervalSeconds < 1 {
-		return fmt.Errorf("app.loop_interval_seconds must be >= 1 when loop=true, got %d", cfg.App.LoopIntervalSeconds)
+	profiles, _ := cfg.SelectSites("")
+	for _, p := range profiles {
+		if err := warmer.CheckWarmSources(p); err != nil {
+			return cfg, err
+		}
 	}
+	return cfg, nil
+}
 
-	// Load validation
-	if cfg.Load.MaxLoad < 0 {
-		return fmt.Errorf("load.max_load must be >= 0, got %f", cfg.Load.MaxLoad)
+// loadSiteConfig loads the config for a command that works on one database.
+// With [[site]] profiles configured, site must name one of them.
+func loadSiteConfig(configPath, site string) (warmer.Config, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return cfg, err
+	}
+	if site == "" && len(cfg.Sites) > 0 {
+		var names []string
+		for _, s := range cfg.Sites {
+			names = append(names, s.Name)
+		}
+		return cfg, fmt.Errorf("config has [[site]] profiles; choose one with -site (%s)", strings.Join(names, ", "))
+	}
+	profiles, err := cfg.SelectSites(site)
+	if err != nil {
+		return cfg, err
+	}
+	sc := profiles[0].Cfg
+	if dbPath := os.Getenv(dbEnvVar); dbPath != "" {
+		sc.App.DBPath = dbPath
 	}
-	if cfg.Load.CheckIntervalSeconds < 1 {
-		return fmt.Errorf("load.check_interval_seconds must be >= 1, got %d", cfg.Load.CheckIntervalSeconds)
+	return sc, nil
+}
+
+// doctorReport prints the doctor checklist and counts critical failures.
+type doctorReport struct {
+	green, red, yellow func(a ...interface{}) string
+	failed             int
+}
+
+func (r *doctorReport) ok(name, detail string) {
+	fmt.Printf("  %s %s: %s\n", r.green("✅"), name, detail)
+}
+
+func (r *doctorReport) fail(name string, err error) {
+	r.failed++
+	fmt.Printf("  %s %s: %s\n", r.red("❌"), name, err)
+}
+
+func (r *doctorReport) warn(name, detail string) {
+	fmt.Printf("  %s %s: %s\n", r.yellow("⚠️ "), name, detail)
+}
+
+// doctorCheckSitemap resolves the sitemap host and sends a HEAD request
+// through the warmer's HTTP client, so TLS and host restrictions apply.
+func doctorCheckSitemap(ctx context.Context, r *doctorReport, w *warmer.CacheWarmer, sitemapURL string) {
+	u, err := url.Parse(sitemapURL)
+	if err != nil || u.Hostname() == "" {
+		r.fail("Sitemap "+sitemapURL, fmt.Errorf("invalid URL"))
+		return
 	}
 
-	// Sitemap URL validation
-	for i, u := range cfg.Sitemaps.URLs {
-		parsed, err := url.Parse(u)
+	if net.ParseIP(u.Hostname()) == nil {
+		lookupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		addrs, err := net.DefaultResolver.LookupHost(lookupCtx, u.Hostname())
+		cancel()
 		if err != nil {
-			return fmt.Errorf("sitemaps.urls[%d] invalid URL %q: %w", i, u, err)
-		}
-		if parsed.Scheme == "" || parsed.Host == "" {
-			return fmt.Errorf("sitemaps.urls[%d] must have scheme and host: %q", i, u)
-		}
-		if parsed.Scheme != "http" && parsed.Scheme != "https" {
-			return fmt.Errorf("sitemaps.urls[%d] scheme must be http or https: %q", i, u)
+			r.fail("DNS "+u.Hostname(), err)
+			return
 		}
+		r.ok("DNS "+u.Hostname(), strings.Join(addrs, ", "))
 	}
 
-	return nil
+	status, err := w.ProbeSitemap(ctx, sitemapURL)
+	if err != nil {
+		r.fail("Sitemap "+sitemapURL, err)
+		return
+	}
+	switch {
+	case status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented:
+		r.warn("Sitemap "+sitemapURL, fmt.Sprintf("HTTP %d, server does not support HEAD", status))
+	case status >= http.StatusBadRequest:
+		r.fail("Sitemap "+sitemapURL, fmt.Errorf("HTTP %d", status))
+	default:
+		r.ok("Sitemap "+sitemapURL, fmt.Sprintf("HTTP %d", status))
+	}
 }
 
-func loadConfig(configPath string) (Config, error) {
-	var cfg Config
+func cmdDoctor(configPath, site string) error {
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	r := &doctorReport{
+		green:  color.New(color.FgGreen).SprintFunc(),
+		red:    color.New(color.FgRed).SprintFunc(),
+		yellow: yellow,
+	}
+
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println("  ", cyan("CACHE WARMER DOCTOR"))
+	fmt.Println(strings.Repeat("=", 70))
+
+	fmt.Printf("\n🩺 %s\n", yellow("ENVIRONMENT"))
+	fmt.Println(strings.Repeat("-", 70))
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		r.fail("Config "+configPath, err)
+		fmt.Println()
+		return fmt.Errorf("config check failed")
+	}
+	r.ok("Config", configPath+" parses and validates")
+
+	if !cfg.Load.IsEnabled() {
+		r.ok("Load monitoring", "disabled (load.enabled = false)")
+	} else if load, err := warmer.Load1m(); err != nil {
+		r.warn("Load monitoring", "/proc/loadavg not readable; max_load pausing is disabled")
+	} else {
+		r.ok("Load monitoring", fmt.Sprintf("/proc/loadavg readable (load %.2f, max_load %.1f)", load, cfg.Load.MaxLoad))
+	}
 
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return cfg, fmt.Errorf("config not found: %s (tip: run `cache-warmer init`)", configPath)
+	if cfg.App.LogFile != "" {
+		if err := warmer.CheckWritable(cfg.App.LogFile); err != nil {
+			r.fail("Log file "+cfg.App.LogFile, err)
+		} else {
+			r.ok("Log file", cfg.App.LogFile+" is writable")
+		}
 	}
 
-	data, err := os.ReadFile(configPath)
+	profiles, err := cfg.SelectSites(site)
 	if err != nil {
-		return cfg, err
+		return err
 	}
+	ctx := context.Background()
+	for _, p := range profiles {
+		title := "SITEMAPS"
+		if p.Name != "" {
+			title = "SITE " + p.Name
+		}
+		fmt.Printf("\n🗺️  %s\n", yellow(title))
+		fmt.Println(strings.Repeat("-", 70))
 
-	if err := toml.Unmarshal(data, &cfg); err != nil {
-		return cfg, err
+		if err := warmer.CheckDBWritable(p.Cfg.App.DBPath); err != nil {
+			r.fail("Database "+p.Cfg.App.DBPath, err)
+		} else {
+			r.ok("Database", p.Cfg.App.DBPath+" is writable")
+		}
+
+		if len(p.Cfg.Sitemaps.URLs) == 0 {
+			r.warn("Sitemaps", "none configured; pass -sitemap to run/once")
+			continue
+		}
+		w, err := warmer.New(p.Cfg, nil)
+		if err != nil {
+			r.fail("HTTP client", err)
+			continue
+		}
+		for _, sm := range p.Cfg.Sitemaps.URLs {
+			doctorCheckSitemap(ctx, r, w, sm)
+		}
 	}
 
-	if len(cfg.Sitemaps.URLs) == 0 {
-		return cfg, fmt.Errorf("no sitemaps configured. Add [sitemaps].urls in config.toml")
+	fmt.Println()
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Println()
+
+	if r.failed > 0 {
+		return fmt.Errorf("%d critical check(s) failed", r.failed)
 	}
+	return nil
+}
 
-	if err := validateConfig(&cfg); err != nil {
-		return cfg, fmt.Errorf("config validation: %w", err)
+func cmdVersion() {
+	v, c, d := version, commit, date
+	if v == "" {
+		v = "dev"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
 	}
+	fmt.Printf("cache-warmer %s (commit %s, built %s, %s)\n", v, c, d, runtime.Version())
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runOptions holds command-line options shared by the run and once commands.
+type runOptions struct {
+	Seed int64  // RNG seed for shuffle_urls; 0 = random
+	Site string // [[site]] to warm; empty = all sites
+
+	PathPrefixes stringList // -prefix, repeatable; URLs matching any are warmed
+	Sitemaps     stringList // -sitemap, repeatable; replaces the configured sitemaps
+	NewOnly      bool       // -new-only: ignore the rewarm policy, warm only unseen URLs
+	Quiet        bool       // -quiet: sets app.quiet
+
+	DBPath     string // -db: database to use instead of app.db_path
+	ConfigDir  string // -config-dir: run every *.toml in this directory concurrently
+	skipHealth bool   // don't serve [health] (flush -now)
+
+	// Config overrides, applied only for flags that were explicitly set
+	Concurrency int
+	MaxLoad     float64
+	MinDelayMS  int
+	set         map[string]bool
+}
 
-	// Resolve paths relative to config file
-	configDir := filepath.Dir(configPath)
-	if !filepath.IsAbs(cfg.App.DBPath) {
-		cfg.App.DBPath = filepath.Join(configDir, cfg.App.DBPath)
+func parseRunFlags(name string, args []string) (string, runOptions) {
+	var opts runOptions
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+	fs.Int64Var(&opts.Seed, "seed", 0, "Seed for shuffle_urls to reproduce a warming order (0 = random)")
+	fs.StringVar(&opts.Site, "site", "", "Warm only this [[site]] profile (default: all sites)")
+	fs.StringVar(&opts.DBPath, "db", "", "Use this database instead of app.db_path (default from $"+dbEnvVar+")")
+	fs.StringVar(&opts.ConfigDir, "config-dir", "", "Run every *.toml config in this directory concurrently")
+	fs.Var(&opts.PathPrefixes, "prefix", "Only warm URLs whose path starts with this prefix (repeatable)")
+	fs.Var(&opts.Sitemaps, "sitemap", "Warm this sitemap instead of the configured ones (repeatable)")
+	fs.BoolVar(&opts.NewOnly, "new-only", false, "Only warm URLs that were never warmed before, ignoring rewarm_after_hours and flushes")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "Don't log successful warms per URL (failures are still logged)")
+	fs.IntVar(&opts.Concurrency, "concurrency", 0, "Override http.concurrency")
+	fs.Float64Var(&opts.MaxLoad, "max-load", 0, "Override load.max_load")
+	fs.IntVar(&opts.MinDelayMS, "min-delay", 0, "Override http.min_delay_ms")
+	fs.Parse(args)
+
+	opts.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+	return *configPath, opts
+}
+
+// applyOverrides copies explicitly set flag values over the loaded config.
+func (o runOptions) applyOverrides(cfg *warmer.Config) error {
+	if o.set["concurrency"] {
+		cfg.HTTP.Concurrency = o.Concurrency
+	}
+	if o.set["max-load"] {
+		cfg.Load.MaxLoad = o.MaxLoad
+	}
+	if o.set["min-delay"] {
+		cfg.HTTP.MinDelayMS = o.MinDelayMS
 	}
-	if cfg.App.LogFile != "" && !filepath.IsAbs(cfg.App.LogFile) {
-		cfg.App.LogFile = filepath.Join(configDir, cfg.App.LogFile)
+	if o.set["quiet"] {
+		cfg.App.Quiet = o.Quiet
 	}
+	if len(o.Sitemaps) > 0 {
+		cfg.Sitemaps.URLs = o.Sitemaps
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("flag override: %w", err)
+	}
+	return nil
+}
 
-	return cfg, nil
+// runnerOptions converts o for warmer.NewRunner. Without -db the database
+// comes from CACHE_WARMER_DB, when set.
+func (o runOptions) runnerOptions() warmer.RunOptions {
+	dbPath := o.DBPath
+	if dbPath == "" {
+		dbPath = os.Getenv(dbEnvVar)
+	}
+	return warmer.RunOptions{
+		Site:         o.Site,
+		DBPath:       dbPath,
+		PathPrefixes: o.PathPrefixes,
+		NewOnly:      o.NewOnly,
+		Seed:         o.Seed,
+		Override:     o.applyOverrides,
+	}
 }
 
-// ============================
-// Main
-// ============================
+// stripNoColorFlag removes every -no-color / --no-color from args so it works
+// as a global flag in any position, and reports whether it was present.
+func stripNoColorFlag(args []string) ([]string, bool) {
+	out := make([]string, 0, len(args))
+	found := false
+	for i, a := range args {
+		if a == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		if a == "-no-color" || a == "--no-color" {
+			found = true
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, found
+}
 
 func main() {
+	// color already disables itself for NO_COLOR, TERM=dumb and non-TTY stdout
+	var noColor bool
+	os.Args, noColor = stripNoColorFlag(os.Args)
+	if noColor {
+		color.NoColor = true
+	}
+
 	if len(os.Args) < 2 {
 		fmt.Println("Usage: cache-warmer <command> [options]")
 		fmt.Println("\nCommands:")
 		fmt.Println("  init              Create default config.toml")
 		fmt.Println("  status            Show dashboard with current status")
+		fmt.Println("  stats             Show only the statistics block")
 		fmt.Println("  run               Run warmer continuously")
 		fmt.Println("  once              Run a single pass and exit")
 		fmt.Println("  flush             Mark cache flush (forces rewarm)")
+		fmt.Println("  history           Show recent run history")
+		fmt.Println("  top               Show the most warmed or most failed URLs")
+		fmt.Println("  list              List tracked URLs with first-seen time and source sitemap")
+		fmt.Println("  reset             Clear warm history (requires -confirm)")
+		fmt.Println("  doctor            Check config, paths and sitemap reachability")
+		fmt.Println("  version           Show version information")
+		fmt.Println("\nGlobal options:")
+		fmt.Println("  -no-color         Disable colored output (also via NO_COLOR)")
 		os.Exit(1)
 	}
 
 	command := os.Args[1]
+	if command == "--version" || command == "-version" {
+		command = "version"
+	}
 
 	// Global flags
-	configPath := flag.String("config", "config.toml", "Path to config TOML")
+	configPath := flag.String("config", warmer.DefaultConfigPath, configFlagUsage)
 
 	switch command {
 	case "init":
@@ -1536,10 +1170,61 @@ func main() {
 		fs := flag.NewFlagSet("status", flag.ExitOnError)
 		recent := fs.Int("recent", 10, "Number of recent URLs to show")
 		failed := fs.Int("failed", 10, "Number of failed URLs to show")
-		configPath := fs.String("config", "config.toml", "Path to config TOML")
+		output := fs.String("output", "", "Write the dashboard to this file instead of stdout (without colors)")
+		dbPath := fs.String("db", "", "Use this database instead of app.db_path (default from $"+dbEnvVar+")")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
 		fs.Parse(os.Args[2:])
 
-		if err := cmdStatus(*configPath, *recent, *failed); err != nil {
+		if err := cmdStatus(*configPath, *site, *dbPath, *output, *recent, *failed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "stats":
+		fs := flag.NewFlagSet("stats", flag.ExitOnError)
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
+		fs.Parse(os.Args[2:])
+
+		if err := cmdStats(*configPath, *site); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "history":
+		fs := flag.NewFlagSet("history", flag.ExitOnError)
+		limit := fs.Int("n", 20, "Number of runs to show")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
+		fs.Parse(os.Args[2:])
+
+		if err := cmdHistory(*configPath, *site, *limit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "top":
+		fs := flag.NewFlagSet("top", flag.ExitOnError)
+		by := fs.String("by", "count", "Rank by warm \"count\" or consecutive \"failures\"")
+		limit := fs.Int("n", 20, "Number of URLs to show")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
+		fs.Parse(os.Args[2:])
+
+		if err := cmdTop(*configPath, *site, *by, *limit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		limit := fs.Int("n", 0, "Number of URLs to list (0 = all)")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
+		fs.Parse(os.Args[2:])
+
+		if err := cmdList(*configPath, *site, *limit); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -1547,34 +1232,59 @@ func main() {
 	case "flush":
 		fs := flag.NewFlagSet("flush", flag.ExitOnError)
 		reason := fs.String("reason", "", "Optional reason for flush")
-		configPath := fs.String("config", "config.toml", "Path to config TOML")
+		now := fs.Bool("now", false, "Run a warm pass right after marking the flush")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
 		fs.Parse(os.Args[2:])
 
-		if err := cmdFlush(*configPath, *reason); err != nil {
+		if err := cmdFlush(*configPath, *site, *reason, *now); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
-	case "run":
-		fs := flag.NewFlagSet("run", flag.ExitOnError)
-		configPath := fs.String("config", "config.toml", "Path to config TOML")
+	case "reset":
+		fs := flag.NewFlagSet("reset", flag.ExitOnError)
+		confirm := fs.Bool("confirm", false, "Confirm that all warm history should be deleted")
+		all := fs.Bool("all", false, "Also clear flush metadata and run history")
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "[[site]] profile to use (required when sites are configured)")
 		fs.Parse(os.Args[2:])
 
-		if err := cmdRun(*configPath, false); err != nil {
+		if err := cmdReset(*configPath, *site, *confirm, *all); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
-	case "once":
-		fs := flag.NewFlagSet("once", flag.ExitOnError)
-		configPath := fs.String("config", "config.toml", "Path to config TOML")
+	case "doctor":
+		fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+		configPath := fs.String("config", warmer.DefaultConfigPath, configFlagUsage)
+		site := fs.String("site", "", "Check only this [[site]] profile (default: all sites)")
 		fs.Parse(os.Args[2:])
 
-		if err := cmdRun(*configPath, true); err != nil {
+		if err := cmdDoctor(*configPath, *site); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
+	case "version":
+		cmdVersion()
+
+	case "run", "once":
+		configPath, opts := parseRunFlags(command, os.Args[2:])
+
+		run := cmdRun
+		if opts.ConfigDir != "" {
+			configPath, run = opts.ConfigDir, cmdRunDir
+		}
+		if err := run(configPath, command == "once", opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			var runErr *warmer.RunFailedError
+			if errors.As(err, &runErr) {
+				os.Exit(exitRunFailed)
+			}
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
 		os.Exit(1)
@@ -1,15 +1,17 @@
-module cache-warmer
+module github.com/hpowernl/cache-warmer
 
 go 1.21
 
 require (
 	github.com/BurntSushi/toml v1.3.2
+	github.com/andybalholm/brotli v1.0.6
 	github.com/fatih/color v1.16.0
 	github.com/mattn/go-sqlite3 v1.14.19
-	golang.org/x/sys v0.14.0
+	golang.org/x/net v0.18.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/sys v0.14.0 // indirect
 )
@@ -0,0 +1,68 @@
+package warmer_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpowernl/cache-warmer/warmer"
+)
+
+// Example_runOnce runs a single pass against a local site from a program of
+// its own, the way deploy tooling embeds the warmer.
+func Example_runOnce() {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			fmt.Fprintf(w, `<urlset><url><loc>%[1]s/</loc></url><url><loc>%[1]s/about</loc></url></urlset>`, srv.URL)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}))
+	defer srv.Close()
+
+	dir, err := os.MkdirTemp("", "warmer-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.toml")
+	// Warm the test server, whatever the load of the machine running it
+	config := strings.NewReplacer(
+		"https://www.demoshop.nl/sitemap.xml", srv.URL+"/sitemap.xml",
+		"enabled = true", "enabled = false", // [load]
+	).Replace(warmer.DefaultConfigTOML)
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := warmer.ReadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := warmer.NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	w, err := warmer.New(cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := w.RunOnce(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("collected=%d ok=%d fail=%d\n", result.URLsCollected, result.OK, result.Fail)
+	// Output: collected=2 ok=2 fail=0
+}
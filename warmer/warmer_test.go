@@ -0,0 +1,652 @@
+package warmer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestWarmer returns a warmer for the default config with sitemapURL as
+// its only sitemap, a database in a temp dir and load gating off. configure,
+// when not nil, adjusts the config before the warmer is built.
+func newTestWarmer(t *testing.T, sitemapURL string, configure func(cfg *Config)) *CacheWarmer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	config := strings.Replace(DefaultConfigTOML, "https://www.demoshop.nl/sitemap.xml", sitemapURL, 1)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := false
+	cfg.Load.Enabled = &off
+	cfg.HTTP.MinDelayMS = 0
+	if configure != nil {
+		configure(&cfg)
+	}
+	db, err := NewWarmDB(cfg.App.DBPath, cfg.App.DBBusyTimeoutMS, cfg.App.DBMaxOpenConns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	w, err := New(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestRateLimiter429IsPerHost(t *testing.T) {
+	rl := newRateLimiter(4, 0, 2, 0, 0)
+	rl.on429("slow.example", 0)
+
+	if got := rl.Snapshot().Current; got != 4 {
+		t.Fatalf("global concurrency = %d after a 429, want 4", got)
+	}
+	if got := rl.hostLimit["slow.example"]; got != 2 {
+		t.Fatalf("slow.example limit = %d, want 2", got)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := rl.acquire(ctx, "slow.example"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := rl.acquire(ctx, "fast.example"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := rl.acquire(short, "slow.example"); err == nil {
+		t.Fatal("acquired a third slot for slow.example over its limit of 2")
+	}
+
+	rl.onSuccess("slow.example")
+	rl.onSuccess("slow.example")
+	if got := rl.hostLimit["slow.example"]; got != 3 {
+		t.Fatalf("slow.example limit = %d after recover_after successes, want 3", got)
+	}
+	rl.onSuccess("slow.example")
+	rl.onSuccess("slow.example")
+	if _, limited := rl.hostLimit["slow.example"]; limited {
+		t.Fatal("slow.example still limited after recovering to full concurrency")
+	}
+}
+
+func TestDecodeSitemapBodyBrotli(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/sitemap.xml.br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://www.example.com/", "https://www.example.com/products"}
+
+	tests := []struct {
+		name            string
+		url             string
+		contentEncoding string
+	}{
+		{"content-encoding", "https://www.example.com/sitemap.xml", "br"},
+		{"suffix", "https://www.example.com/sitemap.xml.br", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := decodeSitemapBody(tt.url, tt.contentEncoding, fixture, 1<<20)
+			if err != nil {
+				t.Fatalf("decodeSitemapBody: %v", err)
+			}
+			_, urls, err := parseSitemapXML(body, sitemapParseOptions{})
+			if err != nil {
+				t.Fatalf("parseSitemapXML: %v", err)
+			}
+			if !reflect.DeepEqual(urls, want) {
+				t.Errorf("urls = %q, want %q", urls, want)
+			}
+		})
+	}
+
+	if _, err := decodeSitemapBody("https://www.example.com/sitemap.xml", "br", fixture, 16); !errors.Is(err, errDecompressedTooLarge) {
+		t.Errorf("over max_decompressed: err = %v, want errDecompressedTooLarge", err)
+	}
+}
+
+func TestParseLoadavg(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    float64
+		wantErr bool
+	}{
+		{"typical", "0.52 0.58 0.59 1/467 12345\n", 0.52, false},
+		{"extra whitespace", "  1.50\t 2.00   3.00  \n\n", 1.5, false},
+		{"only load fields", "4 5 6", 4, false},
+		{"empty", "", 0, true},
+		{"whitespace only", " \n\t", 0, true},
+		{"single field", "0.52", 0, true},
+		{"two fields", "0.52 0.58", 0, true},
+		{"non-numeric", "abc 0.58 0.59 1/467 12345", 0, true},
+		{"non-numeric later field", "0.52 0.58 x 1/467 12345", 0, true},
+		{"trailing garbage", "0.52x 0.58 0.59", 0, true},
+		{"negative", "-1 0.58 0.59", 0, true},
+		{"nan", "NaN 0.58 0.59", 0, true},
+		{"inf", "0.52 Inf 0.59", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLoadavg(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLoadavg(%q) err = %v, wantErr %t", tt.data, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLoadavg(%q) = %g, want %g", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCollectNestedSitemapsConcurrently walks a four-level sitemap index
+// whose branches share sub-indexes from several roots at once, so the race
+// detector sees the seenSitemaps bookkeeping under contention.
+func TestCollectNestedSitemapsConcurrently(t *testing.T) {
+	const fanout = 4
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		// /idx/a/b: a and b pick sub-indexes; /idx/a/b/c is a urlset. Every
+		// /idx/a also lists all /idx/a again, so those are reached through
+		// several parents and in cycles.
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch len(parts) {
+		case 1, 2:
+			fmt.Fprint(w, "<sitemapindex>")
+			for i := 0; i < fanout; i++ {
+				fmt.Fprintf(w, "<sitemap><loc>%s/idx/%d</loc></sitemap>", srv.URL, i)
+				if len(parts) == 2 {
+					fmt.Fprintf(w, "<sitemap><loc>%s/idx/%s/%d</loc></sitemap>", srv.URL, parts[1], i)
+				}
+			}
+			fmt.Fprint(w, "</sitemapindex>")
+		case 3:
+			fmt.Fprint(w, "<sitemapindex>")
+			for i := 0; i < fanout; i++ {
+				fmt.Fprintf(w, "<sitemap><loc>%s%s/%d</loc></sitemap>", srv.URL, r.URL.Path, i)
+			}
+			fmt.Fprint(w, "</sitemapindex>")
+		default:
+			fmt.Fprintf(w, "<urlset><url><loc>%s/page%s</loc></url></urlset>", srv.URL, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestWarmer(t, srv.URL+"/idx", nil)
+	ctx := context.Background()
+	for pass := 0; pass < 2; pass++ {
+		c.resetSeenSitemaps()
+		c.sitemapSlots = make(chan struct{}, 4)
+		mu.Lock()
+		fetches = make(map[string]int)
+		mu.Unlock()
+
+		roots := []string{srv.URL + "/idx", srv.URL + "/idx/0", srv.URL + "/idx/1", srv.URL + "/idx"}
+		results := make([][]collectedURL, len(roots))
+		var wg sync.WaitGroup
+		for i, root := range roots {
+			wg.Add(1)
+			go func(i int, root string) {
+				defer wg.Done()
+				urls, err := c.collectURLsFromSitemap(ctx, root, 0)
+				if err != nil {
+					t.Errorf("collect %s: %v", root, err)
+				}
+				results[i] = urls
+			}(i, root)
+		}
+		wg.Wait()
+
+		seen := make(map[string]bool)
+		for _, urls := range results {
+			for _, u := range urls {
+				if seen[u.URL] {
+					t.Errorf("pass %d: %s collected twice", pass, u.URL)
+				}
+				seen[u.URL] = true
+			}
+		}
+		if want := fanout * fanout * fanout; len(seen) != want {
+			t.Errorf("pass %d: collected %d URLs, want %d", pass, len(seen), want)
+		}
+		mu.Lock()
+		for path, n := range fetches {
+			if n != 1 {
+				t.Errorf("pass %d: %s fetched %d times, want once", pass, path, n)
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+// gzipBytes compresses data. Writes to a bytes.Buffer cannot fail.
+func gzipBytes(data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestCollectGzippedSitemapIndex(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap_index.xml.gz":
+			w.Write(gzipBytes(fmt.Sprintf(`<sitemapindex>
+				<sitemap><loc>%[1]s/products.xml.gz</loc></sitemap>
+				<sitemap><loc>%[1]s/pages.xml</loc></sitemap>
+			</sitemapindex>`, srv.URL)))
+		case "/products.xml.gz":
+			w.Write(gzipBytes(fmt.Sprintf(`<urlset><url><loc>%[1]s/p/1</loc></url><url><loc>%[1]s/p/2</loc></url></urlset>`, srv.URL)))
+		case "/pages.xml":
+			// Gzipped without a .gz name, announced by Content-Encoding
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipBytes(fmt.Sprintf(`<urlset><url><loc>%s/about</loc></url></urlset>`, srv.URL)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestWarmer(t, srv.URL+"/sitemap_index.xml.gz", nil)
+	c.resetSeenSitemaps()
+	c.sitemapSlots = make(chan struct{}, 4)
+	collected, err := c.collectURLsFromSitemap(context.Background(), srv.URL+"/sitemap_index.xml.gz", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range collected {
+		got = append(got, u.URL)
+	}
+	want := []string{srv.URL + "/p/1", srv.URL + "/p/2", srv.URL + "/about"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collected %q, want %q", got, want)
+	}
+}
+
+func TestSiteProfilesSummaryFile(t *testing.T) {
+	cfg := Config{Sites: []SiteConfig{{Name: "shop", DBPath: "shop.db"}, {Name: "blog", DBPath: "blog.db"}}}
+	cfg.App.SummaryFile = "/var/lib/warmer/summary.json"
+	var got []string
+	for _, p := range cfg.siteProfiles() {
+		got = append(got, p.Cfg.App.SummaryFile)
+	}
+	want := []string{"/var/lib/warmer/summary.shop.json", "/var/lib/warmer/summary.blog.json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summary files = %q, want %q", got, want)
+	}
+
+	cfg.App.SummaryFile = ""
+	for _, p := range cfg.siteProfiles() {
+		if p.Cfg.App.SummaryFile != "" {
+			t.Errorf("site %s: summary_file = %q, want disabled", p.Name, p.Cfg.App.SummaryFile)
+		}
+	}
+}
+
+// orderServer serves a sitemap of n pages listed in reverse order and
+// records the order in which the pages are requested.
+func orderServer(t *testing.T, n int) (srv *httptest.Server, requested func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			fmt.Fprint(w, "<urlset>")
+			for i := n - 1; i >= 0; i-- {
+				fmt.Fprintf(w, "<url><loc>%s/p%02d</loc></url>", srv.URL, i)
+			}
+			fmt.Fprint(w, "</urlset>")
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func TestSortURLsWarmsInOrder(t *testing.T) {
+	srv, requested := orderServer(t, 30)
+	c := newTestWarmer(t, srv.URL+"/sitemap.xml", func(cfg *Config) {
+		cfg.HTTP.Concurrency = 1
+		cfg.App.SortURLs = true
+	})
+	if _, err := c.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := requested()
+	if len(got) != 30 {
+		t.Fatalf("warmed %d pages, want 30", len(got))
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Errorf("pages warmed out of order: %q", got)
+	}
+}
+
+func TestShuffleSeedReproducesWarmOrder(t *testing.T) {
+	var orders [2][]string
+	for i := range orders {
+		srv, requested := orderServer(t, 30)
+		c := newTestWarmer(t, srv.URL+"/sitemap.xml", func(cfg *Config) {
+			cfg.HTTP.Concurrency = 1
+			cfg.App.ShuffleURLs = true
+		})
+		c.setSeed(42)
+		if _, err := c.RunOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		orders[i] = requested()
+	}
+	if len(orders[0]) != 30 || !reflect.DeepEqual(orders[0], orders[1]) {
+		t.Errorf("seed 42 warmed\n%q\nthen\n%q", orders[0], orders[1])
+	}
+}
+
+func TestMaxRunDurationStopsRun(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			fmt.Fprint(w, "<urlset>")
+			for i := 0; i < 20; i++ {
+				fmt.Fprintf(w, "<url><loc>%s/slow%d</loc></url>", srv.URL, i)
+			}
+			fmt.Fprint(w, "</urlset>")
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestWarmer(t, srv.URL+"/sitemap.xml", func(cfg *Config) {
+		cfg.HTTP.Concurrency = 2
+		cfg.App.MaxRunDurationSeconds = 1
+	})
+	rec, err := c.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Interrupted || rec.OK != 0 || rec.Fail != 0 {
+		t.Errorf("run record = %+v, want interrupted with no ok or failed warms", rec)
+	}
+	if c.ready.Load() {
+		t.Error("ready after a run stopped by max_run_duration_seconds")
+	}
+	stats, err := c.db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.WarmedTotal != 0 {
+		t.Errorf("%d warms recorded for in-flight requests cut short by the deadline, want 0", stats.WarmedTotal)
+	}
+}
+
+func TestDialGuardHostAllowed(t *testing.T) {
+	g, err := newDialGuard(HTTPConfig{AllowedHosts: []string{"www.example.com", " *.Cdn.Example.NET "}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"www.example.com", true},
+		{"WWW.Example.com", true},
+		{"www.example.com.", true},
+		{"example.com", false},
+		{"shop.example.com", false},
+		{"img.cdn.example.net", true},
+		{"a.b.cdn.example.net", true},
+		{"cdn.example.net", false},
+		{"evilcdn.example.net", false},
+		{"www.example.com.evil.test", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := g.hostAllowed(tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %t, want %t", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestDialGuardCheckIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     HTTPConfig
+		ip      string
+		blocked bool
+	}{
+		{"public allowed", HTTPConfig{BlockPrivateNetworks: true}, "93.184.216.34", false},
+		{"loopback", HTTPConfig{BlockPrivateNetworks: true}, "127.0.0.1", true},
+		{"loopback v6", HTTPConfig{BlockPrivateNetworks: true}, "::1", true},
+		{"private 10/8", HTTPConfig{BlockPrivateNetworks: true}, "10.1.2.3", true},
+		{"private 192.168/16", HTTPConfig{BlockPrivateNetworks: true}, "192.168.0.10", true},
+		{"private v6 ula", HTTPConfig{BlockPrivateNetworks: true}, "fd00::1", true},
+		{"link-local metadata", HTTPConfig{BlockPrivateNetworks: true}, "169.254.169.254", true},
+		{"unspecified", HTTPConfig{BlockPrivateNetworks: true}, "0.0.0.0", true},
+		{"v4-mapped loopback", HTTPConfig{BlockPrivateNetworks: true}, "::ffff:127.0.0.1", true},
+		{"private allowed without block_private_networks", HTTPConfig{BlockedCIDRs: []string{"203.0.113.0/24"}}, "10.1.2.3", false},
+		{"blocked cidr", HTTPConfig{BlockedCIDRs: []string{"203.0.113.0/24"}}, "203.0.113.7", true},
+		{"outside blocked cidr", HTTPConfig{BlockedCIDRs: []string{"203.0.113.0/24"}}, "203.0.114.7", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newDialGuard(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = g.checkIP(net.ParseIP(tt.ip))
+			if (err != nil) != tt.blocked {
+				t.Errorf("checkIP(%s) = %v, want blocked=%t", tt.ip, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestDialGuardRefusesLoopbackUnlessAllowed(t *testing.T) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, "<urlset></urlset>")
+	}))
+	defer srv.Close()
+	sitemapURL := srv.URL + "/sitemap.xml"
+
+	tests := []struct {
+		name    string
+		allowed []string
+		refused bool
+	}{
+		{"blocked", nil, true},
+		{"other host allowlisted", []string{"www.example.com"}, true},
+		{"allowlisted", []string{"127.0.0.1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestWarmer(t, sitemapURL, func(cfg *Config) {
+				cfg.HTTP.BlockPrivateNetworks = true
+				cfg.HTTP.AllowedHosts = tt.allowed
+				cfg.HTTP.Retries = 0
+			})
+			before := hits.Load()
+
+			_, err := c.fetchBytes(context.Background(), sitemapURL)
+			if (err != nil) != tt.refused {
+				t.Errorf("sitemap fetch err = %v, want refused=%t", err, tt.refused)
+			}
+			status, errMsg, _ := c.warmOne(context.Background(), srv.URL+"/page", "", nil, nil)
+			if (errMsg != "") != tt.refused {
+				t.Errorf("warm status=%d err=%q, want refused=%t", status, errMsg, tt.refused)
+			}
+			if got := hits.Load() - before; tt.refused && got != 0 {
+				t.Errorf("server got %d requests despite the guard", got)
+			} else if !tt.refused && got != 2 {
+				t.Errorf("server got %d requests, want the sitemap fetch and the warm", got)
+			}
+		})
+	}
+}
+
+// baselineSchema is the schema of databases created before migrations
+// existed, plus run_history as it was first added.
+const baselineSchema = `
+CREATE TABLE warmed_url (
+  url TEXT PRIMARY KEY,
+  last_warmed_utc TEXT,
+  last_status INTEGER,
+  last_error TEXT,
+  warmed_count INTEGER DEFAULT 0
+);
+CREATE TABLE sitemap_seen (
+  sitemap_url TEXT PRIMARY KEY,
+  last_fetched_utc TEXT,
+  last_error TEXT
+);
+CREATE TABLE meta (
+  k TEXT PRIMARY KEY,
+  v TEXT
+);
+CREATE TABLE run_history (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  started_utc TEXT,
+  finished_utc TEXT,
+  urls_collected INTEGER,
+  urls_warmed INTEGER,
+  ok INTEGER,
+  fail INTEGER,
+  interrupted INTEGER DEFAULT 0
+);
+INSERT INTO warmed_url(url, last_warmed_utc, last_status, warmed_count)
+  VALUES('https://www.example.com/', '2025-01-02T03:04:05Z', 200, 3);
+`
+
+func tableColumns(t *testing.T, db *sql.DB, table string) map[string]bool {
+	t.Helper()
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			t.Fatal(err)
+		}
+		cols[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cols
+}
+
+func TestMigrateBaselineDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warmer.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	w, err := NewWarmDB(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := w.SchemaVersion(); err != nil || v != len(migrations) {
+		t.Fatalf("schema_version = %d (%v), want %d", v, err, len(migrations))
+	}
+	want := map[string][]string{
+		"warmed_url":   {"error_class", "consecutive_failures", "source_sitemap", "first_seen_utc"},
+		"sitemap_seen": {"url_count", "max_url_count"},
+		"run_history":  {"bytes", "cache_hits", "cache_misses", "run_id"},
+	}
+	for table, cols := range want {
+		have := tableColumns(t, w.db, table)
+		for _, col := range cols {
+			if !have[col] {
+				t.Errorf("%s.%s missing after migration", table, col)
+			}
+		}
+	}
+	var firstSeen sql.NullString
+	if err := w.db.QueryRow("SELECT first_seen_utc FROM warmed_url").Scan(&firstSeen); err != nil {
+		t.Fatal(err)
+	}
+	if firstSeen.String != "2025-01-02T03:04:05Z" {
+		t.Errorf("first_seen_utc = %q, want backfilled from last_warmed_utc", firstSeen.String)
+	}
+
+	// A row the backfill would touch shows whether migrations run again
+	if _, err := w.db.Exec(`INSERT INTO warmed_url(url, last_warmed_utc, last_status)
+		VALUES('https://www.example.com/new', '2025-02-03T04:05:06Z', 200)`); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w, err = NewWarmDB(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if v, err := w.SchemaVersion(); err != nil || v != len(migrations) {
+		t.Errorf("schema_version after reopen = %d (%v), want %d", v, err, len(migrations))
+	}
+	if err := w.db.QueryRow("SELECT first_seen_utc FROM warmed_url WHERE url = 'https://www.example.com/new'").Scan(&firstSeen); err != nil {
+		t.Fatal(err)
+	}
+	if firstSeen.Valid {
+		t.Errorf("reopening re-ran migrations: first_seen_utc backfilled to %q", firstSeen.String)
+	}
+}